@@ -5,3 +5,16 @@ const (
 	LabelStorageDir = "org.ustcmirror.storage-dir"
 	LabelImages     = "org.ustcmirror.images"
 )
+
+// Special exit codes recorded in the metadata.
+const (
+	// ExitCodeNeverSynced indicates that the repo has not been synced yet.
+	ExitCodeNeverSynced = -1
+	// ExitCodeTimeout indicates that the sync container was killed due to timeout.
+	ExitCodeTimeout = -2
+	// ExitCodeCancelled indicates that the sync was cancelled through the API.
+	ExitCodeCancelled = -3
+	// ExitCodeLost indicates that the sync container disappeared before its result was collected,
+	// e.g. it was removed while yukid was not running.
+	ExitCodeLost = -4
+)
@@ -3,15 +3,38 @@ package api
 type ListRepoMetasResponse = []GetRepoMetaResponse
 
 type GetRepoMetaResponse struct {
-	Name        string `json:"name"`
-	Upstream    string `json:"upstream"`
-	Syncing     bool   `json:"syncing"`
-	Size        int64  `json:"size"`
-	ExitCode    int    `json:"exitCode"`
-	LastSuccess int64  `json:"lastSuccess"`
-	UpdatedAt   int64  `json:"updatedAt"`
-	PrevRun     int64  `json:"prevRun"`
-	NextRun     int64  `json:"nextRun"`
+	Name         string `json:"name"`
+	Upstream     string `json:"upstream"`
+	Syncing      bool   `json:"syncing"`
+	Size         int64  `json:"size"`
+	ExitCode     int    `json:"exitCode"`
+	LastSuccess  int64  `json:"lastSuccess"`
+	UpdatedAt    int64  `json:"updatedAt"`
+	PrevRun      int64  `json:"prevRun"`
+	NextRun      int64  `json:"nextRun"`
+	Paused       bool   `json:"paused"`
+	PausedReason string `json:"pausedReason,omitempty"`
+	PausedUntil  int64  `json:"pausedUntil,omitempty"`
+	// Stale means the repo has not synced successfully within its maxStaleness.
+	Stale bool `json:"stale"`
+}
+
+type ListRepoMetasRequest struct {
+	// Stale filters the repos by their stale flag if set.
+	Stale *bool `query:"stale"`
+}
+
+type GetRepoBadgeRequest struct {
+	// Style is the style of the badge. Empty means "flat".
+	Style string `query:"style" validate:"omitempty,oneof=flat flat-square"`
+	// Label is the text on the left of the badge. Empty means the repo name.
+	Label string `query:"label"`
+}
+
+type PauseRepoRequest struct {
+	Reason string `json:"reason"`
+	// Until is the unix timestamp when the repo will be resumed automatically. Zero means never.
+	Until int64 `json:"until" validate:"min=0"`
 }
 
 type ListReposResponseItem struct {
@@ -22,3 +45,209 @@ type ListReposResponseItem struct {
 }
 
 type ListReposResponse = []ListReposResponseItem
+
+type ListSyncRecordsRequest struct {
+	Page     int `query:"page"`
+	PageSize int `query:"pageSize"`
+}
+
+type SyncRecord struct {
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	StartedAt  int64  `json:"startedAt"`
+	FinishedAt int64  `json:"finishedAt"`
+	ExitCode   int    `json:"exitCode"`
+	TimedOut   bool   `json:"timedOut"`
+	Cancelled  bool   `json:"cancelled"`
+	SizeBefore int64  `json:"sizeBefore"`
+	SizeAfter  int64  `json:"sizeAfter"`
+	Upstream   string `json:"upstream"`
+	Debug      bool   `json:"debug"`
+	Trigger    string `json:"trigger"`
+	// Image is the image ref used by the sync.
+	Image string `json:"image"`
+	// ImageDigest is the repo digest of the image actually used by the sync.
+	ImageDigest string `json:"imageDigest"`
+}
+
+type ListSyncRecordsResponse struct {
+	Total   int64        `json:"total"`
+	Records []SyncRecord `json:"records"`
+}
+
+type LogFileInfo struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+}
+
+type ListLogFilesResponse = []LogFileInfo
+
+// Event types of the sync lifecycle.
+const (
+	EventSyncScheduled    = "sync_scheduled"
+	EventContainerStarted = "container_started"
+	EventSyncFinished     = "sync_finished"
+	EventSyncTimeout      = "sync_timeout"
+	EventRepoAdded        = "repo_added"
+	EventRepoRemoved      = "repo_removed"
+	EventImagesUpgraded   = "images_upgraded"
+	EventCanaryStarted    = "canary_started"
+	EventCanaryPromoted   = "canary_promoted"
+	EventCanaryFailed     = "canary_failed"
+	EventRepoStale        = "repo_stale"
+	EventRepoFresh        = "repo_fresh"
+)
+
+type Event struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	// Time is the unix timestamp when the event happened.
+	Time     int64    `json:"time"`
+	Repo     string   `json:"repo,omitempty"`
+	ExitCode *int     `json:"exitCode,omitempty"`
+	NextRun  int64    `json:"nextRun,omitempty"`
+	Images   []string `json:"images,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Notification events that trigger the webhooks.
+const (
+	NotifyOnFailure  = "failure"
+	NotifyOnRecovery = "recovery"
+	NotifyOnTimeout  = "timeout"
+	NotifyOnStale    = "stale"
+	NotifyOnFresh    = "fresh"
+)
+
+// WebhookPayload is the default body of the webhooks. It is also the data of the body templates.
+type WebhookPayload struct {
+	Event               string `json:"event"`
+	Repo                string `json:"repo"`
+	ExitCode            int    `json:"exitCode"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	StartedAt           int64  `json:"startedAt"`
+	FinishedAt          int64  `json:"finishedAt"`
+	Size                int64  `json:"size"`
+	Upstream            string `json:"upstream"`
+	// LastSuccess is the unix timestamp of the last successful sync. It is only set for stale and fresh events.
+	LastSuccess int64 `json:"lastSuccess,omitempty"`
+}
+
+type ListNotificationDeliveriesRequest struct {
+	Notifier string `query:"notifier"`
+	Repo     string `query:"repo"`
+	Page     int    `query:"page"`
+	PageSize int    `query:"pageSize"`
+}
+
+type NotificationDelivery struct {
+	ID         uint   `json:"id"`
+	Notifier   string `json:"notifier"`
+	Repo       string `json:"repo"`
+	Event      string `json:"event"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"statusCode"`
+	Error      string `json:"error"`
+	Delivered  bool   `json:"delivered"`
+	CreatedAt  int64  `json:"createdAt"`
+}
+
+type ListNotificationDeliveriesResponse struct {
+	Total      int64                  `json:"total"`
+	Deliveries []NotificationDelivery `json:"deliveries"`
+}
+
+// Triggers of reloading all repos.
+const (
+	ReloadTriggerAPI   = "api"
+	ReloadTriggerWatch = "watch"
+)
+
+type ConfigError struct {
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	Error string `json:"error"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+type RepoDiff struct {
+	Name   string        `json:"name"`
+	Fields []FieldChange `json:"fields"`
+}
+
+type ReloadResult struct {
+	// Time is the unix timestamp when the reload started.
+	Time    int64  `json:"time"`
+	Trigger string `json:"trigger"`
+	// DryRun means that the changes are not applied.
+	DryRun  bool          `json:"dryRun,omitempty"`
+	Loaded  int           `json:"loaded"`
+	Added   []string      `json:"added"`
+	Removed []string      `json:"removed"`
+	Changed []RepoDiff    `json:"changed"`
+	Errors  []ConfigError `json:"errors"`
+	// Warnings are the unknown or mistyped fields when strict_repo_config is "warn".
+	Warnings []ConfigError `json:"warnings"`
+}
+
+// MirrorzResponse is the mirror site information in the mirrorz format.
+// See also https://github.com/mirrorz-org/mirrorz#data-format
+type MirrorzResponse struct {
+	Version float64         `json:"version"`
+	Site    MirrorzSite     `json:"site"`
+	Info    []any           `json:"info"`
+	Mirrors []MirrorzMirror `json:"mirrors"`
+}
+
+type MirrorzSite struct {
+	URL          string `json:"url"`
+	Logo         string `json:"logo,omitempty"`
+	LogoDarkmode string `json:"logo_darkmode,omitempty"`
+	Abbr         string `json:"abbr"`
+	Name         string `json:"name,omitempty"`
+	Homepage     string `json:"homepage,omitempty"`
+	Issue        string `json:"issue,omitempty"`
+	Request      string `json:"request,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Group        string `json:"group,omitempty"`
+	Disk         string `json:"disk,omitempty"`
+	Note         string `json:"note,omitempty"`
+	Big          string `json:"big,omitempty"`
+	Banner       string `json:"banner,omitempty"`
+}
+
+type MirrorzMirror struct {
+	Cname string `json:"cname"`
+	Desc  string `json:"desc"`
+	URL   string `json:"url"`
+	// Status is a sequence of flags, each optionally followed by a unix timestamp.
+	// e.g. "S1700000000X1700086400" means the last sync succeeded at 1700000000 and the next sync starts at 1700086400.
+	Status   string `json:"status"`
+	Help     string `json:"help"`
+	Upstream string `json:"upstream"`
+	Size     string `json:"size"`
+}
+
+// TunasyncJob is the status of a repo in the format of the /jobs API of tunasync.
+// The times are formatted as "2006-01-02 15:04:05 -0700" and are empty if unknown.
+type TunasyncJob struct {
+	Name           string `json:"name"`
+	IsMaster       bool   `json:"is_master"`
+	Status         string `json:"status"`
+	LastUpdate     string `json:"last_update"`
+	LastUpdateTs   int64  `json:"last_update_ts"`
+	LastStarted    string `json:"last_started"`
+	LastStartedTs  int64  `json:"last_started_ts"`
+	LastEnded      string `json:"last_ended"`
+	LastEndedTs    int64  `json:"last_ended_ts"`
+	NextSchedule   string `json:"next_schedule"`
+	NextScheduleTs int64  `json:"next_schedule_ts"`
+	Upstream       string `json:"upstream"`
+	Size           string `json:"size"`
+}
@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const dockerHubHost = "docker.io"
+
+// RegistryAuth is the credential of a registry.
+type RegistryAuth struct {
+	Username string
+	Password string
+	// IdentityToken is used instead of Username and Password if set.
+	IdentityToken string
+}
+
+// Credentials resolves the credentials of registries by their hosts.
+type Credentials struct {
+	// File is a docker config.json. It is read on every pull so that the changes take effect without restarting yukid.
+	File string
+	// Auths maps the registry hosts to their credentials. They take precedence over File.
+	Auths map[string]RegistryAuth
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// normalizeRegistryHost turns the keys of config.json such as "https://index.docker.io/v1/" into hosts.
+func normalizeRegistryHost(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	host = strings.ToLower(host)
+	switch host {
+	case "", "index.docker.io", "registry-1.docker.io":
+		return dockerHubHost
+	}
+	return host
+}
+
+// readDockerConfig reads the auths in a docker config.json. Credential helpers are not supported.
+func readDockerConfig(path string) (map[string]RegistryAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Auths map[string]dockerConfigAuth `json:"auths"`
+	}
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	auths := make(map[string]RegistryAuth, len(cfg.Auths))
+	for host, a := range cfg.Auths {
+		auth := RegistryAuth{
+			Username:      a.Username,
+			Password:      a.Password,
+			IdentityToken: a.IdentityToken,
+		}
+		if len(a.Auth) > 0 {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s: invalid auth of %q: %w", path, host, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		auths[normalizeRegistryHost(host)] = auth
+	}
+	return auths, nil
+}
+
+// Lookup returns the credential of the given registry host.
+func (c Credentials) Lookup(host string) (RegistryAuth, bool, error) {
+	host = normalizeRegistryHost(host)
+	for h, auth := range c.Auths {
+		if normalizeRegistryHost(h) == host {
+			return auth, true, nil
+		}
+	}
+	if len(c.File) == 0 {
+		return RegistryAuth{}, false, nil
+	}
+	auths, err := readDockerConfig(c.File)
+	if err != nil {
+		return RegistryAuth{}, false, fmt.Errorf("read registry credentials: %w", err)
+	}
+	auth, ok := auths[host]
+	return auth, ok, nil
+}
+
+// credsFunction adapts the credential to image.PullConfig.CredsFunction.
+func (a RegistryAuth) credsFunction(string) (string, string, error) {
+	if len(a.IdentityToken) > 0 {
+		return "<token>", a.IdentityToken, nil
+	}
+	return a.Username, a.Password, nil
+}
@@ -0,0 +1,55 @@
+package docker
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	testutils "github.com/ustclug/Yuki/test/utils"
+)
+
+func TestCredentialsLookup(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	testutils.WriteFile(t, configPath, `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hub:secret"))+`"},
+    "registry.example.com": {"identitytoken": "token"},
+    "ghcr.io": {"username": "file", "password": "file"}
+  }
+}`)
+	creds := Credentials{
+		File: configPath,
+		Auths: map[string]RegistryAuth{
+			"ghcr.io": {Username: "daemon", Password: "daemon"},
+		},
+	}
+
+	auth, ok, err := creds.Lookup("docker.io")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, RegistryAuth{Username: "hub", Password: "secret"}, auth)
+
+	auth, ok, err = creds.Lookup("registry.example.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	user, pass, err := auth.credsFunction("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "<token>", user)
+	require.Equal(t, "token", pass)
+
+	// The auths in daemon.toml take precedence over the file.
+	auth, ok, err = creds.Lookup("ghcr.io")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "daemon", auth.Username)
+
+	_, ok, err = creds.Lookup("quay.io")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	creds.File = filepath.Join(t.TempDir(), "missing.json")
+	_, _, err = creds.Lookup("quay.io")
+	require.Error(t, err)
+}
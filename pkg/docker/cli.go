@@ -2,7 +2,11 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/cpuguy83/go-docker"
@@ -18,15 +22,40 @@ import (
 	"github.com/ustclug/Yuki/pkg/api"
 )
 
+// Pull policies of RunContainer.
+const (
+	// PullAlways pulls the image before creating every container.
+	PullAlways = "always"
+	// PullIfNotPresent pulls the image only if it does not exist.
+	PullIfNotPresent = "ifNotPresent"
+	// PullNever never pulls the image, so the container fails to start if the image does not exist.
+	PullNever = "never"
+)
+
 type RunContainerConfig struct {
 	// ContainerConfig
 	Labels map[string]string
 	Env    []string
-	Image  string
-	Name   string
+	// Image can be pinned by digest like "ustcmirror/rsync@sha256:...".
+	Image string
+	Name  string
+	// PullPolicy decides when the image is pulled. Empty means PullIfNotPresent.
+	PullPolicy string
 
 	// HostConfig
 	Binds []string
+	// Resources. Zero values mean the docker defaults.
+	CPUShares   int64
+	Memory      int64
+	PidsLimit   int64
+	BlkioWeight uint16
+	// Security
+	ReadOnlyRootfs bool
+	CapAdd         []string
+	CapDrop        []string
+	SecurityOpt    []string
+	// Tmpfs maps the paths of the extra tmpfs mounts to their options.
+	Tmpfs map[string]string
 
 	// NetworkingConfig
 	Network string
@@ -42,18 +71,29 @@ type Client interface {
 	// The specified image will be pulled automatically if it does not exist.
 	RunContainer(ctx context.Context, config RunContainerConfig) (id string, err error)
 	WaitContainerWithTimeout(id string, timeout time.Duration) (int, error)
+	// StopContainerWithTimeout stops the given container gracefully.
+	StopContainerWithTimeout(id string, timeout time.Duration) error
 	RemoveContainerWithTimeout(id string, timeout time.Duration) error
 	ListContainersWithTimeout(running bool, timeout time.Duration) ([]ContainerSummary, error)
-	UpgradeImages(refs []string) error
+	// PullImages pulls the given images concurrently.
+	PullImages(refs []string) error
+	// RemoveDanglingImages removes the dangling images used by the sync containers.
+	// The images with any repo digest in keep are not removed.
+	RemoveDanglingImages(keep []string) error
+	// ImageDigest returns the repo digest like "ustcmirror/rsync@sha256:..." of the local image.
+	// It returns an empty string if the image does not exist or has no repo digest, e.g. it is built locally.
+	ImageDigest(ctx context.Context, ref string) (string, error)
 }
 
-func NewClient(endpoint string) (Client, error) {
+// NewClient creates a client of the docker daemon. creds is used when pulling images.
+func NewClient(endpoint string, creds Credentials) (Client, error) {
 	tr, err := transport.FromConnectionString(endpoint)
 	if err != nil {
 		return nil, err
 	}
 	return &clientImpl{
 		client: docker.NewClient(docker.WithTransport(tr)),
+		creds:  creds,
 	}, nil
 }
 
@@ -64,8 +104,45 @@ func getTimeoutContext(timeout time.Duration) (context.Context, context.CancelFu
 	return context.WithTimeout(context.Background(), timeout)
 }
 
+// NewHostConfig returns the HostConfig of the container created by RunContainer.
+func NewHostConfig(config RunContainerConfig) containerapi.HostConfig {
+	hostConfig := containerapi.HostConfig{
+		Binds: config.Binds,
+		Resources: containerapi.Resources{
+			CPUShares:   config.CPUShares,
+			Memory:      config.Memory,
+			BlkioWeight: config.BlkioWeight,
+		},
+		ReadonlyRootfs: config.ReadOnlyRootfs,
+		CapAdd:         config.CapAdd,
+		CapDrop:        config.CapDrop,
+		SecurityOpt:    config.SecurityOpt,
+		Tmpfs:          config.Tmpfs,
+	}
+	if config.PidsLimit > 0 {
+		hostConfig.PidsLimit = &config.PidsLimit
+	}
+	// /tmp is always a tmpfs unless its options are overridden.
+	if _, ok := config.Tmpfs["/tmp"]; !ok {
+		hostConfig.Mounts = []mount.Mount{
+			{
+				Type:   mount.TypeTmpfs,
+				Target: "/tmp",
+			},
+		}
+	}
+	switch config.Network {
+	case "host", "":
+		hostConfig.NetworkMode = "host"
+	default:
+		hostConfig.NetworkMode = config.Network
+	}
+	return hostConfig
+}
+
 type clientImpl struct {
 	client *docker.Client
+	creds  Credentials
 }
 
 func (c *clientImpl) RunContainer(ctx context.Context, config RunContainerConfig) (id string, err error) {
@@ -78,27 +155,18 @@ func (c *clientImpl) RunContainer(ctx context.Context, config RunContainerConfig
 			Labels:    config.Labels,
 		}
 
-		cfg.Spec.HostConfig = containerapi.HostConfig{
-			Binds: config.Binds,
-		}
-		cfg.Spec.HostConfig.Mounts = []mount.Mount{
-			{
-				Type:   mount.TypeTmpfs,
-				Target: "/tmp",
-			},
-		}
-
+		cfg.Spec.HostConfig = NewHostConfig(config)
 		cfg.Spec.NetworkConfig.EndpointsConfig = make(map[string]*containerapi.EndpointSettings)
-		switch config.Network {
-		case "host", "":
-			cfg.Spec.HostConfig.NetworkMode = "host"
-		default:
-			cfg.Spec.HostConfig.NetworkMode = config.Network
+	}
+	if config.PullPolicy == PullAlways {
+		err = c.pullImage(ctx, config.Image)
+		if err != nil {
+			return "", fmt.Errorf("pull image: %w", err)
 		}
 	}
 	ct, err := c.client.ContainerService().Create(ctx, "", setCfg)
 	if err != nil {
-		if errdefs.IsNotFound(err) {
+		if errdefs.IsNotFound(err) && config.PullPolicy != PullNever {
 			err = c.pullImage(ctx, config.Image)
 			if err != nil {
 				return "", fmt.Errorf("pull image: %w", err)
@@ -156,6 +224,13 @@ func (c *clientImpl) RemoveContainerWithTimeout(id string, timeout time.Duration
 	})
 }
 
+func (c *clientImpl) StopContainerWithTimeout(id string, timeout time.Duration) error {
+	ctx, cancel := getTimeoutContext(timeout)
+	defer cancel()
+	ct := c.client.ContainerService().NewContainer(ctx, id)
+	return ct.Stop(ctx)
+}
+
 func (c *clientImpl) WaitContainerWithTimeout(id string, timeout time.Duration) (int, error) {
 	ctx, cancel := getTimeoutContext(timeout)
 	defer cancel()
@@ -167,12 +242,71 @@ func (c *clientImpl) WaitContainerWithTimeout(id string, timeout time.Duration)
 	return status.ExitCode()
 }
 
+var digestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ParseImageRef is like image.ParseRef but also supports the refs pinned by digest like "ustcmirror/rsync@sha256:...".
+func ParseImageRef(ref string) (image.Remote, error) {
+	name, digest, pinned := strings.Cut(ref, "@")
+	remote, err := image.ParseRef(name)
+	if err != nil {
+		return remote, err
+	}
+	if pinned {
+		if !digestRegexp.MatchString(digest) {
+			return remote, fmt.Errorf("invalid digest: %q", digest)
+		}
+		remote.Tag = digest
+	}
+	return remote, nil
+}
+
+// IsPinned reports whether the image ref is pinned by digest.
+func IsPinned(ref string) bool {
+	return strings.Contains(ref, "@")
+}
+
 func (c *clientImpl) pullImage(ctx context.Context, ref string) error {
-	remote, err := image.ParseRef(ref)
+	remote, err := ParseImageRef(ref)
 	if err != nil {
 		return fmt.Errorf("invalid image ref: %w", err)
 	}
-	return c.client.ImageService().Pull(ctx, remote)
+	auth, ok, err := c.creds.Lookup(remote.Host)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return c.client.ImageService().Pull(ctx, remote)
+	}
+	return c.client.ImageService().Pull(ctx, remote, func(cfg *image.PullConfig) error {
+		cfg.CredsFunction = auth.credsFunction
+		return nil
+	})
+}
+
+func (c *clientImpl) ImageDigest(ctx context.Context, ref string) (string, error) {
+	images, err := c.client.ImageService().List(ctx, func(cfg *image.ListConfig) {
+		cfg.Digests = true
+		cfg.Filter = image.ListFilter{
+			Reference: []string{ref},
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 || len(images[0].RepoDigests) == 0 {
+		return "", nil
+	}
+	// Prefer the digest of the same repository if the image is tagged in several repositories.
+	name, _, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	for _, d := range images[0].RepoDigests {
+		if strings.HasPrefix(d, name+"@") {
+			return d, nil
+		}
+	}
+	return images[0].RepoDigests[0], nil
 }
 
 func (c *clientImpl) removeImage(id string, timeout time.Duration) error {
@@ -182,24 +316,32 @@ func (c *clientImpl) removeImage(id string, timeout time.Duration) error {
 	return err
 }
 
-func (c *clientImpl) removeDanglingImages() error {
+func (c *clientImpl) RemoveDanglingImages(keep []string) error {
 	images, err := c.listDanglingImages(time.Second * 5)
 	if err != nil {
 		return fmt.Errorf("list images: %w", err)
 	}
+	var errs []error
 	for _, img := range images {
+		if slices.ContainsFunc(img.RepoDigests, func(d string) bool {
+			return slices.Contains(keep, d)
+		}) {
+			continue
+		}
+		// The images still used by containers cannot be removed. Try the others anyway.
 		err = c.removeImage(img.ID, time.Second*20)
 		if err != nil {
-			return fmt.Errorf("remove image: %q: %w", img.ID, err)
+			errs = append(errs, fmt.Errorf("remove image: %q: %w", img.ID, err))
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (c *clientImpl) listDanglingImages(timeout time.Duration) ([]imageapi.Image, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	return c.client.ImageService().List(ctx, func(cfg *image.ListConfig) {
+		cfg.Digests = true
 		cfg.Filter = image.ListFilter{
 			Label:    []string{api.LabelImages},
 			Dangling: []string{"true"},
@@ -207,7 +349,7 @@ func (c *clientImpl) listDanglingImages(timeout time.Duration) ([]imageapi.Image
 	})
 }
 
-func (c *clientImpl) UpgradeImages(refs []string) error {
+func (c *clientImpl) PullImages(refs []string) error {
 	eg, ctx := errgroup.WithContext(context.Background())
 	eg.SetLimit(5)
 	for _, ref := range refs {
@@ -218,10 +360,5 @@ func (c *clientImpl) UpgradeImages(refs []string) error {
 			return c.pullImage(pullCtx, img)
 		})
 	}
-	err := eg.Wait()
-	if err != nil {
-		return err
-	}
-
-	return c.removeDanglingImages()
+	return eg.Wait()
 }
@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageRef(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	remote, err := ParseImageRef("ustcmirror/rsync@" + digest)
+	require.NoError(t, err)
+	require.Equal(t, "docker.io", remote.Host)
+	require.Equal(t, "ustcmirror/rsync", remote.Locator)
+	require.Equal(t, digest, remote.Tag)
+
+	remote, err = ParseImageRef("registry.example.com/mirror/rsync:v1")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com", remote.Host)
+	require.Equal(t, "mirror/rsync", remote.Locator)
+	require.Equal(t, "v1", remote.Tag)
+
+	_, err = ParseImageRef("ustcmirror/rsync@sha256:abc")
+	require.Error(t, err)
+}
@@ -3,17 +3,55 @@ package fake
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/cpuguy83/go-docker/container/containerapi"
 	"github.com/cpuguy83/go-docker/errdefs"
 
 	"github.com/ustclug/Yuki/pkg/docker"
 )
 
+type container struct {
+	summary docker.ContainerSummary
+	stopped chan struct{}
+}
+
+// Run is a recorded call of RunContainer.
+type Run struct {
+	Config     docker.RunContainerConfig
+	HostConfig containerapi.HostConfig
+}
+
 type Client struct {
 	mu         sync.Mutex
-	containers map[string]docker.ContainerSummary
+	containers map[string]*container
+	runs       []Run
+	digests    map[string]string
+}
+
+// SetImageDigest sets the digest returned by ImageDigest for the given ref.
+func (f *Client) SetImageDigest(ref, digest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.digests[ref] = digest
+}
+
+func (f *Client) ImageDigest(ctx context.Context, ref string) (string, error) {
+	if docker.IsPinned(ref) {
+		return ref, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.digests[ref], nil
+}
+
+// Runs returns the calls of RunContainer in order, including the removed containers.
+func (f *Client) Runs() []Run {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.runs)
 }
 
 func (f *Client) RunContainer(ctx context.Context, config docker.RunContainerConfig) (id string, err error) {
@@ -23,28 +61,57 @@ func (f *Client) RunContainer(ctx context.Context, config docker.RunContainerCon
 	if ok {
 		return "", errdefs.Conflict("container already exists")
 	}
-	f.containers[config.Name] = docker.ContainerSummary{
-		ID:     config.Name,
-		Labels: config.Labels,
+	f.runs = append(f.runs, Run{
+		Config:     config,
+		HostConfig: docker.NewHostConfig(config),
+	})
+	f.containers[config.Name] = &container{
+		summary: docker.ContainerSummary{
+			ID:     config.Name,
+			Labels: config.Labels,
+		},
+		stopped: make(chan struct{}),
 	}
 	return config.Name, nil
 }
 
 func (f *Client) WaitContainerWithTimeout(id string, timeout time.Duration) (int, error) {
 	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	_, ok := f.containers[id]
+	ct, ok := f.containers[id]
+	f.mu.Unlock()
 	if !ok {
 		return 0, fmt.Errorf("container %s not found", id)
 	}
 	const delay = 5 * time.Second
 	if timeout > 0 && timeout < delay {
-		time.Sleep(timeout)
-		return 0, context.DeadlineExceeded
+		select {
+		case <-time.After(timeout):
+			return 0, context.DeadlineExceeded
+		case <-ct.stopped:
+			return 137, nil
+		}
+	}
+	select {
+	case <-time.After(delay):
+		return 0, nil
+	case <-ct.stopped:
+		return 137, nil
 	}
-	time.Sleep(delay)
-	return 0, nil
+}
+
+func (f *Client) StopContainerWithTimeout(id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ct, ok := f.containers[id]
+	if !ok {
+		return errdefs.NotFoundf("container %s not found", id)
+	}
+	select {
+	case <-ct.stopped:
+	default:
+		close(ct.stopped)
+	}
+	return nil
 }
 
 func (f *Client) RemoveContainerWithTimeout(id string, timeout time.Duration) error {
@@ -59,17 +126,22 @@ func (f *Client) ListContainersWithTimeout(running bool, timeout time.Duration)
 	defer f.mu.Unlock()
 	l := make([]docker.ContainerSummary, 0, len(f.containers))
 	for _, ct := range f.containers {
-		l = append(l, ct)
+		l = append(l, ct.summary)
 	}
 	return l, nil
 }
 
-func (f *Client) UpgradeImages(refs []string) error {
+func (f *Client) PullImages(refs []string) error {
 	panic("not implemented")
 }
 
+func (f *Client) RemoveDanglingImages(keep []string) error {
+	return nil
+}
+
 func NewClient() docker.Client {
 	return &Client{
-		containers: make(map[string]docker.ContainerSummary),
+		containers: make(map[string]*container),
+		digests:    make(map[string]string),
 	}
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes that can be written as a string like "512m" or "4GiB" in JSON and YAML.
+// The units are binary as in docker, i.e. 1k = 1024 bytes.
+type ByteSize int64
+
+var byteSizeUnits = map[string]float64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+	"p": 1 << 50,
+}
+
+var byteSizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?) ?([kmgtp]?)i?b?$`)
+
+// ParseByteSize parses a size like "512m", "1.5g" or "4GiB".
+func ParseByteSize(s string) (ByteSize, error) {
+	matches := byteSizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	n, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return byteSizeFromFloat(n * byteSizeUnits[matches[2]])
+}
+
+// byteSizeFromFloat converts the number of bytes into ByteSize. The fraction of a byte is truncated.
+func byteSizeFromFloat(n float64) (ByteSize, error) {
+	// float64(math.MaxInt64) is rounded up to 1<<63, which overflows int64.
+	if n >= math.MaxInt64 {
+		return 0, fmt.Errorf("size too large: %v bytes", n)
+	}
+	return ByteSize(n), nil
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case float64:
+		parsed, err := byteSizeFromFloat(val)
+		if err != nil {
+			return err
+		}
+		*b = parsed
+	case string:
+		parsed, err := ParseByteSize(val)
+		if err != nil {
+			return err
+		}
+		*b = parsed
+	default:
+		return fmt.Errorf("invalid size: %s", data)
+	}
+	return nil
+}
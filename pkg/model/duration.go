@@ -0,0 +1,28 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is encoded as a string like "1h30m" in JSON and YAML.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	// Bare numbers are rejected since they have no unit.
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid duration %s: expect a string like \"10m\"", data)
+	}
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,23 @@
+package model
+
+// Statuses of ImageRollout.
+const (
+	// RolloutCanary means the candidate is being tried by the canary repo.
+	RolloutCanary = "canary"
+	// RolloutFailed means the canary sync failed with the candidate, so all repos use the stable image.
+	RolloutFailed = "failed"
+)
+
+// ImageRollout tracks an upgraded image that is tried by a canary repo before the other repos.
+// It is removed once the candidate is promoted.
+type ImageRollout struct {
+	Image string `gorm:"primaryKey"`
+	// Stable is the repo digest of the image used by the other repos.
+	Stable string
+	// Candidate is the repo digest of the upgraded image used by the canary repo.
+	Candidate  string
+	CanaryRepo string
+	Status     string
+	CreatedAt  int64 `gorm:"autoCreateTime"`
+	UpdatedAt  int64 `gorm:"autoUpdateTime"`
+}
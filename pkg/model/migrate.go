@@ -12,5 +12,5 @@ func AutoMigrate(db *gorm.DB) error {
 	if err != nil {
 		return fmt.Errorf("set WAL mode: %w", err)
 	}
-	return db.AutoMigrate(&Repo{}, &RepoMeta{})
+	return db.AutoMigrate(&Repo{}, &RepoMeta{}, &SyncRecord{}, &NotificationDelivery{}, &ImageRollout{})
 }
@@ -0,0 +1,17 @@
+package model
+
+// NotificationDelivery records the result of delivering a webhook notification.
+type NotificationDelivery struct {
+	ID       uint   `gorm:"primaryKey"`
+	Notifier string `gorm:"index"`
+	Repo     string `gorm:"index"`
+	Event    string
+	// Attempts is the number of requests sent, including the retries.
+	Attempts int
+	// StatusCode is the HTTP status code of the last response. Zero means no response is received.
+	StatusCode int
+	// Error is the error of the last attempt.
+	Error     string
+	Delivered bool
+	CreatedAt int64 `gorm:"autoCreateTime"`
+}
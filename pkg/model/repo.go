@@ -2,6 +2,38 @@ package model
 
 type StringMap map[string]string
 
+// RetryPolicy decides whether and when yukid reschedules a failed sync.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of retries after a failed sync.
+	MaxAttempts int `json:"maxAttempts" validate:"min=0"`
+	// BaseDelay is the delay before the first retry. It is doubled after each retry.
+	BaseDelay Duration `json:"baseDelay" validate:"min=0"`
+	// MaxDelay caps the delay between retries. Zero means no cap.
+	MaxDelay Duration `json:"maxDelay" validate:"min=0"`
+	// ExitCodes are the retryable exit codes. Empty means all non-zero exit codes.
+	ExitCodes []int `json:"exitCodes"`
+	// RetryOnTimeout decides whether timeout syncs are retryable.
+	RetryOnTimeout bool `json:"retryOnTimeout"`
+}
+
+// SecurityOptions hardens the sync container. The unset fields fall back to container_security of yukid.
+type SecurityOptions struct {
+	// ReadOnlyRootfs mounts the root filesystem of the container as read-only.
+	ReadOnlyRootfs *bool `json:"readOnlyRootfs,omitempty"`
+	// CapDrop are the capabilities to drop, e.g. ["ALL"].
+	CapDrop []string `json:"capDrop,omitempty"`
+	// CapAdd are the capabilities to add, e.g. ["CHOWN", "SETUID"].
+	CapAdd []string `json:"capAdd,omitempty"`
+	// NoNewPrivileges prevents the processes in the container from gaining new privileges.
+	NoNewPrivileges *bool `json:"noNewPrivileges,omitempty"`
+	// SeccompProfile is the path of a seccomp profile on the host of yukid, or "unconfined".
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+	// AppArmorProfile is the name of an AppArmor profile loaded on the docker host, or "unconfined".
+	AppArmorProfile string `json:"appArmorProfile,omitempty"`
+	// Tmpfs are the extra tmpfs mounts in the form of "path[:options]", e.g. "/run:size=64m".
+	Tmpfs []string `json:"tmpfs,omitempty" validate:"dive,startswith=/"`
+}
+
 // Repo represents a Repository.
 type Repo struct {
 	Name string `gorm:"primaryKey" json:"name" validate:"required,repo-name"`
@@ -16,6 +48,40 @@ type Repo struct {
 	Retry       int       `json:"retry"  validate:"min=0"`
 	Envs        StringMap `gorm:"type:text;serializer:json" json:"envs"`
 	Volumes     StringMap `gorm:"type:text;serializer:json" json:"volumes"`
+	// ConcurrencyGroup is the name of the group whose concurrency limit applies to the repo.
+	ConcurrencyGroup string `json:"concurrencyGroup"`
+	// Priority decides the order of queued syncs. Larger value goes first.
+	Priority int `json:"priority"`
+	// RetryPolicy decides how yukid retries failed syncs. Nil means never.
+	RetryPolicy *RetryPolicy `gorm:"type:text;serializer:json" json:"retryPolicy,omitempty"`
+	// Timeout overrides sync_timeout of yukid. Zero means sync_timeout.
+	Timeout Duration `json:"timeout" validate:"min=0"`
+	// CPUShares is the relative CPU weight of the container. Zero means the docker default (1024).
+	CPUShares int64 `json:"cpuShares" validate:"min=0"`
+	// Memory is the memory limit of the container. Zero means unlimited.
+	Memory ByteSize `json:"memory" validate:"min=0"`
+	// PidsLimit is the maximum number of processes in the container. Zero means unlimited.
+	PidsLimit int64 `json:"pidsLimit" validate:"min=0"`
+	// BlkioWeight is the relative block IO weight of the container, ranging from 10 to 1000. Zero means the docker default.
+	BlkioWeight uint16 `json:"blkioWeight" validate:"omitempty,min=10,max=1000"`
+	// MaxStaleness is how long the repo may go without a successful sync before it is considered stale.
+	// Zero means max_staleness of yukid.
+	MaxStaleness Duration `json:"maxStaleness" validate:"min=0"`
+	// PullPolicy decides when the image is pulled. Empty means ifNotPresent.
+	PullPolicy string `json:"pullPolicy" validate:"omitempty,oneof=always ifNotPresent never"`
+	// Canary makes the repo try the upgraded image before the other repos using the same image.
+	Canary bool `json:"canary"`
+	// Description is the short description of the repo shown on the status pages.
+	Description string `json:"description"`
+	// HelpURL is the URL of the usage guide of the repo.
+	HelpURL string `json:"helpURL"`
+	// Security hardens the sync container. Nil means container_security of yukid.
+	Security *SecurityOptions `gorm:"type:text;serializer:json" json:"security,omitempty"`
+	// APIManaged means the repo is applied through the API without a config file.
+	// It is kept by the reloads of all repos until it is deleted or gets a config file.
+	APIManaged bool `json:"-"`
+	// ConfigFile is the name of the config file that the repo is loaded from.
+	ConfigFile string `json:"-"`
 	// sqlite3 does not have builtin datetime type
 	CreatedAt int64 `gorm:"autoCreateTime" json:"-"`
 	UpdatedAt int64 `gorm:"autoUpdateTime" json:"-"`
@@ -33,4 +99,13 @@ type RepoMeta struct {
 	PrevRun     int64
 	NextRun     int64
 	Syncing     bool
+	// Paused repos will not be scheduled.
+	Paused       bool
+	PausedReason string
+	// PausedUntil is the time when the repo will be resumed automatically. Zero means never.
+	PausedUntil int64
+	// RetryAttempt is the number of retries scheduled since the last success.
+	RetryAttempt int
+	// Stale is updated periodically according to the maxStaleness of the repo.
+	Stale bool
 }
@@ -0,0 +1,32 @@
+package model
+
+const (
+	// SyncTriggerSchedule means the sync is started by the scheduler.
+	SyncTriggerSchedule = "schedule"
+	// SyncTriggerManual means the sync is started through the API.
+	SyncTriggerManual = "manual"
+	// SyncTriggerRetry means the sync is a retry of a failed sync.
+	SyncTriggerRetry = "retry"
+	// SyncTriggerUnknown means the sync is found running when yukid starts.
+	SyncTriggerUnknown = "unknown"
+)
+
+// SyncRecord represents a single run of syncing a Repository.
+type SyncRecord struct {
+	ID         uint   `gorm:"primaryKey"`
+	Name       string `gorm:"index"`
+	StartedAt  int64
+	FinishedAt int64
+	ExitCode   int
+	TimedOut   bool
+	Cancelled  bool
+	SizeBefore int64
+	SizeAfter  int64
+	Upstream   string
+	Debug      bool
+	Trigger    string
+	// Image is the image ref used by the sync, which is pinned by digest during a canary rollout.
+	Image string
+	// ImageDigest is the repo digest of the image actually used by the sync.
+	ImageDigest string
+}
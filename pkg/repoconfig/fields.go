@@ -0,0 +1,255 @@
+package repoconfig
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+var (
+	repoType     = reflect.TypeOf(model.Repo{})
+	durationType = reflect.TypeOf(model.Duration(0))
+	byteSizeType = reflect.TypeOf(model.ByteSize(0))
+)
+
+// FieldIssue is an unknown or mistyped field in a config file.
+type FieldIssue struct {
+	// File is the path of the config file. It is only set by the methods of Loader.
+	File    string
+	Line    int
+	Column  int
+	Field   string
+	Message string
+}
+
+func (i FieldIssue) String() string {
+	if len(i.File) > 0 {
+		return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// CheckFields reports the unknown and mistyped fields in a repo config file.
+func CheckFields(data []byte) ([]FieldIssue, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	var issues []FieldIssue
+	checkMapping(doc.Content[0], repoType, "", true, &issues)
+	return issues, nil
+}
+
+// CheckDefaultsFields reports the unknown and mistyped fields in defaults.yaml.
+func CheckDefaultsFields(data []byte) ([]FieldIssue, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	var issues []FieldIssue
+	if root.Kind != yaml.MappingNode {
+		return append(issues, newFieldIssue(root, "", "expected a mapping")), nil
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		switch k.Value {
+		case "defaults":
+			checkMapping(v, repoType, k.Value, false, &issues)
+		case "templates":
+			if v.Kind != yaml.MappingNode {
+				if !isNull(v) {
+					issues = append(issues, newFieldIssue(v, k.Value, "expected a mapping"))
+				}
+				continue
+			}
+			for j := 0; j+1 < len(v.Content); j += 2 {
+				checkMapping(v.Content[j+1], repoType, "templates."+v.Content[j].Value, true, &issues)
+			}
+		default:
+			issues = append(issues, newFieldIssue(k, k.Value, fmt.Sprintf("unknown field %q", k.Value)))
+		}
+	}
+	return issues, nil
+}
+
+func newFieldIssue(node *yaml.Node, field, msg string) FieldIssue {
+	return FieldIssue{
+		Line:    node.Line,
+		Column:  node.Column,
+		Field:   field,
+		Message: msg,
+	}
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+func joinPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonFields returns the fields of the struct type indexed by their JSON names.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// suggest returns the known field that the unknown field is probably a typo of.
+func suggest(name string, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+	for known := range fields {
+		if strings.EqualFold(known, name) {
+			return known
+		}
+		if d := editDistance(strings.ToLower(known), strings.ToLower(name)); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func checkMapping(node *yaml.Node, t reflect.Type, path string, allowExtends bool, issues *[]FieldIssue) {
+	if node.Kind != yaml.MappingNode {
+		if !isNull(node) {
+			*issues = append(*issues, newFieldIssue(node, path, "expected a mapping"))
+		}
+		return
+	}
+	fields := jsonFields(t)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		field := joinPath(path, k.Value)
+		if allowExtends && k.Value == keyExtends {
+			if v.ShortTag() != "!!str" {
+				*issues = append(*issues, newFieldIssue(v, field, fmt.Sprintf("field %q expects a template name", field)))
+			}
+			continue
+		}
+		f, ok := fields[k.Value]
+		if !ok {
+			msg := fmt.Sprintf("unknown field %q", field)
+			if s := suggest(k.Value, fields); len(s) > 0 {
+				msg += fmt.Sprintf(", did you mean %q?", s)
+			}
+			*issues = append(*issues, newFieldIssue(k, field, msg))
+			continue
+		}
+		checkValue(v, f.Type, field, issues)
+	}
+}
+
+// checkValue reports the value that cannot be decoded into the given type.
+func checkValue(node *yaml.Node, t reflect.Type, field string, issues *[]FieldIssue) {
+	if isNull(node) {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	mistyped := func(expected string) {
+		got := node.Value
+		if node.Kind != yaml.ScalarNode {
+			got = "a " + map[yaml.Kind]string{
+				yaml.MappingNode:  "mapping",
+				yaml.SequenceNode: "list",
+			}[node.Kind]
+		} else {
+			got = fmt.Sprintf("%q", got)
+		}
+		*issues = append(*issues, newFieldIssue(node, field, fmt.Sprintf("field %q expects %s, got %s", field, expected, got)))
+	}
+	isScalar := func(tags ...string) bool {
+		return node.Kind == yaml.ScalarNode && slices.Contains(tags, node.ShortTag())
+	}
+
+	switch {
+	case t == durationType:
+		// A bare number has no unit, which would be read as nanoseconds.
+		if !isScalar("!!str") {
+			mistyped(`a duration like "10m"`)
+		}
+	case t == byteSizeType:
+		if !isScalar("!!str", "!!int") {
+			mistyped(`a size like "4g"`)
+		}
+	case t.Kind() == reflect.Struct:
+		checkMapping(node, t, field, false, issues)
+	case t.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			mistyped("a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkValue(node.Content[i+1], t.Elem(), joinPath(field, node.Content[i].Value), issues)
+		}
+	case t.Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			mistyped("a list")
+			return
+		}
+		for i, item := range node.Content {
+			checkValue(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i), issues)
+		}
+	case t.Kind() == reflect.String:
+		if !isScalar("!!str") {
+			mistyped("a string (quote the value if necessary)")
+		}
+	case t.Kind() == reflect.Bool:
+		if !isScalar("!!bool") {
+			mistyped("a boolean")
+		}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if !isScalar("!!int") {
+			mistyped("an integer")
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package repoconfig
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Severities of lint issues.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is a problem found by Lint.
+type Issue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Repo     string `json:"repo,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (i Issue) String() string {
+	loc := i.File
+	if i.Line > 0 {
+		loc += fmt.Sprintf(":%d", i.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, i.Severity, i.Message)
+}
+
+type LintOptions struct {
+	// Files limits the configs to lint. Empty means all configs in the dirs.
+	Files []string
+	// SkipDirCheck skips checking the existence of storageDir, which is useful when linting on another host.
+	SkipDirCheck bool
+}
+
+// Lint runs the same checks as yukid on the configs in the given dirs without loading them.
+func Lint(dirs []string, opts LintOptions) ([]Issue, error) {
+	var issues []Issue
+	validate := NewValidator()
+	validateFunc := validate.Struct
+	if opts.SkipDirCheck {
+		validateFunc = func(i any) error {
+			return skipDirErrors(validate.Struct(i))
+		}
+	}
+
+	// Files are linted by their names since the files with the same name in different dirs are merged.
+	// The issues of the merged config are reported on the file in the last dir, which takes precedence.
+	files := make(map[string]string)
+	// The files that are not valid YAML. They are reported only once.
+	broken := make(map[string]struct{})
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			switch {
+			case e.Name() == DefaultsFile:
+				fileIssues, _ := checkFileFields(path, CheckDefaultsFields)
+				issues = append(issues, fileIssues...)
+			case IsConfigFile(e.Name()):
+				if len(opts.Files) > 0 && !slices.Contains(opts.Files, e.Name()) {
+					continue
+				}
+				fileIssues, ok := checkFileFields(path, CheckFields)
+				issues = append(issues, fileIssues...)
+				if !ok {
+					broken[e.Name()] = struct{}{}
+				}
+				files[e.Name()] = path
+			}
+		}
+	}
+
+	loader, err := NewLoader(dirs)
+	if err != nil {
+		// The error of defaults.yaml has been reported.
+		return sortIssues(issues), nil
+	}
+	// The config files that define each repo.
+	names := make(map[string][]string)
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		if _, ok := broken[file]; ok {
+			continue
+		}
+		path := files[file]
+		repo, err := loader.Load(file)
+		if err != nil {
+			issues = append(issues, Issue{
+				File:     path,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Fail to parse config: %v", err),
+			})
+			continue
+		}
+		_, err = Validate(validateFunc, repo, file)
+		if err != nil {
+			issues = append(issues, Issue{
+				File:     path,
+				Repo:     repo.Name,
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+		if len(repo.Name) == 0 {
+			continue
+		}
+		names[repo.Name] = append(names[repo.Name], path)
+		if repo.Name+".yaml" != file {
+			issues = append(issues, Issue{
+				File:     path,
+				Repo:     repo.Name,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("File name does not match the repo name %q, so `yukictl reload %s` cannot find it", repo.Name, repo.Name),
+			})
+		}
+	}
+	for name, paths := range names {
+		if len(paths) < 2 {
+			continue
+		}
+		for _, path := range paths {
+			issues = append(issues, Issue{
+				File:     path,
+				Repo:     name,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Duplicate repo name %q in %s", name, strings.Join(paths, ", ")),
+			})
+		}
+	}
+
+	return sortIssues(issues), nil
+}
+
+func sortIssues(issues []Issue) []Issue {
+	slices.SortStableFunc(issues, func(a, b Issue) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return issues
+}
+
+// checkFileFields reports the unknown fields in the file. ok is false if the file cannot be parsed.
+func checkFileFields(path string, check func([]byte) ([]FieldIssue, error)) (issues []Issue, ok bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []Issue{{File: path, Severity: SeverityError, Message: err.Error()}}, false
+	}
+	fieldIssues, err := check(data)
+	if err != nil {
+		return []Issue{{File: path, Severity: SeverityError, Message: fmt.Sprintf("Fail to parse config: %v", err)}}, false
+	}
+	issues = make([]Issue, len(fieldIssues))
+	for i, fi := range fieldIssues {
+		issues[i] = Issue{
+			File:     path,
+			Line:     fi.Line,
+			Severity: SeverityError,
+			Message:  fi.Message,
+		}
+	}
+	return issues, true
+}
+
+// skipDirErrors drops the errors of the dir validations.
+func skipDirErrors(err error) error {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	var kept validator.ValidationErrors
+	for _, e := range errs {
+		if e.Tag() != "dir" {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
@@ -0,0 +1,118 @@
+package repoconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFields(t *testing.T) {
+	issues, err := CheckFields([]byte(`
+name: debian
+storagedir: /tmp
+env:
+  A: B
+extends: rsync
+retryPolicy:
+  maxAttempt: 3
+`))
+	require.NoError(t, err)
+	require.Equal(t, []FieldIssue{
+		{Line: 3, Column: 1, Field: "storagedir", Message: `unknown field "storagedir", did you mean "storageDir"?`},
+		{Line: 4, Column: 1, Field: "env", Message: `unknown field "env", did you mean "envs"?`},
+		{Line: 8, Column: 3, Field: "retryPolicy.maxAttempt", Message: `unknown field "retryPolicy.maxAttempt", did you mean "maxAttempts"?`},
+	}, issues)
+
+	issues, err = CheckDefaultsFields([]byte(`
+defaults:
+  extends: a
+templates:
+  a:
+    extends: b
+    foo: bar
+others: 1
+`))
+	require.NoError(t, err)
+	require.Len(t, issues, 3)
+	require.Equal(t, "defaults.extends", issues[0].Field)
+	require.Equal(t, "templates.a.foo", issues[1].Field)
+	require.Equal(t, "others", issues[2].Field)
+}
+
+func TestCheckFieldsTypes(t *testing.T) {
+	issues, err := CheckFields([]byte(`
+name: debian
+user: 1000
+retry: many
+extends: [a]
+envs:
+  RSYNC_MAXDELETE: 200000
+volumes: /data
+retryPolicy:
+  baseDelay: true
+  maxDelay: 30
+  exitCodes: [1, "2"]
+  retryOnTimeout: "yes"
+`))
+	require.NoError(t, err)
+	var msgs []string
+	for _, i := range issues {
+		msgs = append(msgs, i.String())
+	}
+	require.Equal(t, []string{
+		`line 3: field "user" expects a string (quote the value if necessary), got "1000"`,
+		`line 4: field "retry" expects an integer, got "many"`,
+		`line 5: field "extends" expects a template name`,
+		`line 7: field "envs.RSYNC_MAXDELETE" expects a string (quote the value if necessary), got "200000"`,
+		`line 8: field "volumes" expects a mapping, got "/data"`,
+		`line 10: field "retryPolicy.baseDelay" expects a duration like "10m", got "true"`,
+		`line 11: field "retryPolicy.maxDelay" expects a duration like "10m", got "30"`,
+		`line 12: field "retryPolicy.exitCodes[1]" expects an integer, got "2"`,
+		`line 13: field "retryPolicy.retryOnTimeout" expects a boolean, got "yes"`,
+	}, msgs)
+}
+
+func TestLint(t *testing.T) {
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+	writeFile(t, filepath.Join(dir1, DefaultsFile), `
+templates:
+  alpine:
+    image: alpine:latest
+    storageDir: /tmp
+`)
+	writeFile(t, filepath.Join(dir1, "good.yaml"), "name: good\nextends: alpine\ncron: '* * * * *'")
+	// Overridden by the file in dir2.
+	writeFile(t, filepath.Join(dir1, "cron.yaml"), "name: cron\nextends: alpine\ncron: '* * * * *'")
+	writeFile(t, filepath.Join(dir2, "cron.yaml"), "cron: invalid")
+	writeFile(t, filepath.Join(dir2, "typo.yaml"), "name: typo\nextends: alpine\ncron: '* * * * *'\nbindip: 1.2.3.4")
+	writeFile(t, filepath.Join(dir2, "dup.yaml"), "name: good\nextends: alpine\ncron: '* * * * *'")
+	writeFile(t, filepath.Join(dir2, "dir.yaml"), "name: dir\nextends: alpine\ncron: '* * * * *'\nstorageDir: /no/such/dir")
+	writeFile(t, filepath.Join(dir2, "broken.yaml"), "name: [")
+
+	issues, err := Lint([]string{dir1, dir2}, LintOptions{})
+	require.NoError(t, err)
+	var msgs []string
+	for _, i := range issues {
+		msgs = append(msgs, i.String())
+	}
+	good := filepath.Join(dir1, "good.yaml")
+	dup := filepath.Join(dir2, "dup.yaml")
+	require.Equal(t, []string{
+		good + `: error: Duplicate repo name "good" in ` + dup + ", " + good,
+		filepath.Join(dir2, "broken.yaml") + ": error: Fail to parse config: yaml: line 1: did not find expected node content",
+		filepath.Join(dir2, "cron.yaml") + `: error: Invalid cron: "invalid": expected exactly 5 fields, found 1: [invalid]`,
+		filepath.Join(dir2, "dir.yaml") + `: error: Invalid config: "dir.yaml": Key: 'Repo.StorageDir' Error:Field validation for 'StorageDir' failed on the 'dir' tag`,
+		dup + `: warning: File name does not match the repo name "good", so ` + "`yukictl reload good`" + ` cannot find it`,
+		dup + `: error: Duplicate repo name "good" in ` + dup + ", " + good,
+		filepath.Join(dir2, "typo.yaml") + `:4: error: unknown field "bindip", did you mean "bindIP"?`,
+	}, msgs)
+
+	issues, err = Lint([]string{dir1, dir2}, LintOptions{
+		Files:        []string{"dir.yaml"},
+		SkipDirCheck: true,
+	})
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
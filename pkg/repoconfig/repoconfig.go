@@ -0,0 +1,242 @@
+// Package repoconfig loads the repo configs in repo_config_dir.
+//
+// A repo config is rendered by merging the following layers in order, where the later layers override the former:
+//
+//  1. The defaults in defaults.yaml.
+//  2. The template referenced by the `extends` field of the repo, and the templates it extends recursively.
+//  3. The config of the repo itself.
+//
+// Maps such as envs and volumes are merged key by key, while other values are replaced as a whole.
+// Each layer can be split across several config dirs, in which case the dirs are merged in order as well.
+package repoconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const (
+	// DefaultsFile is the name of the file that contains the defaults and the templates.
+	DefaultsFile = "defaults.yaml"
+
+	keyExtends = "extends"
+)
+
+// ErrNotFound means that the config does not exist in any config dir.
+var ErrNotFound = errors.New("config not found")
+
+type defaultsFile struct {
+	Defaults  map[string]any            `json:"defaults"`
+	Templates map[string]map[string]any `json:"templates"`
+}
+
+// Loader renders the repo configs in the given dirs.
+type Loader struct {
+	dirs      []string
+	defaults  map[string]any
+	templates map[string]map[string]any
+}
+
+// NewLoader reads the defaults.yaml in the given dirs.
+func NewLoader(dirs []string) (*Loader, error) {
+	l := &Loader{
+		dirs:      dirs,
+		defaults:  map[string]any{},
+		templates: map[string]map[string]any{},
+	}
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, DefaultsFile))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var f defaultsFile
+		err = yaml.Unmarshal(data, &f)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", filepath.Join(dir, DefaultsFile), err)
+		}
+		l.defaults = merge(l.defaults, f.Defaults)
+		for name, tmpl := range f.Templates {
+			l.templates[name] = merge(l.templates[name], tmpl)
+		}
+	}
+	return l, nil
+}
+
+// IsConfigFile reports whether the file in repo_config_dir is a repo config.
+func IsConfigFile(name string) bool {
+	return len(name) > 0 && name[0] != '.' && filepath.Ext(name) == ".yaml" && name != DefaultsFile
+}
+
+// merge merges src into dst recursively and returns dst.
+func merge(dst, src map[string]any) map[string]any {
+	if dst == nil {
+		dst = make(map[string]any, len(src))
+	}
+	for k, v := range src {
+		srcMap, ok := v.(map[string]any)
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dstMap, _ := dst[k].(map[string]any)
+		dst[k] = merge(dstMap, srcMap)
+	}
+	return dst
+}
+
+// clone returns a deep copy of the map so that merging into it does not modify the original.
+func clone(m map[string]any) map[string]any {
+	return merge(nil, m)
+}
+
+// resolveTemplate returns the template merged with the templates it extends.
+func (l *Loader) resolveTemplate(name string, visited []string) (map[string]any, error) {
+	for _, v := range visited {
+		if v == name {
+			return nil, fmt.Errorf("circular template: %q", append(visited, name))
+		}
+	}
+	tmpl, ok := l.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template: %q", name)
+	}
+	res := map[string]any{}
+	if parent, ok := tmpl[keyExtends]; ok {
+		parentName, ok := parent.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid extends of template %q: %v", name, parent)
+		}
+		var err error
+		res, err = l.resolveTemplate(parentName, append(visited, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	res = merge(res, tmpl)
+	delete(res, keyExtends)
+	return res, nil
+}
+
+// CheckFields reports the unknown and mistyped fields in the given config file of all dirs.
+func (l *Loader) CheckFields(file string) ([]FieldIssue, error) {
+	return l.checkFields(file, CheckFields)
+}
+
+// CheckDefaultsFields reports the unknown and mistyped fields in defaults.yaml of all dirs.
+func (l *Loader) CheckDefaultsFields() ([]FieldIssue, error) {
+	return l.checkFields(DefaultsFile, CheckDefaultsFields)
+}
+
+func (l *Loader) checkFields(file string, check func([]byte) ([]FieldIssue, error)) ([]FieldIssue, error) {
+	var issues []FieldIssue
+	for _, dir := range l.dirs {
+		path := filepath.Join(dir, file)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		fileIssues, err := check(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		for _, i := range fileIssues {
+			i.File = path
+			issues = append(issues, i)
+		}
+	}
+	return issues, nil
+}
+
+// Render returns the effective config of the given file.
+func (l *Loader) Render(file string) (map[string]any, error) {
+	var (
+		cfg   map[string]any
+		found bool
+	)
+	for _, dir := range l.dirs {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		var m map[string]any
+		err = yaml.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+		cfg = merge(cfg, m)
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return l.RenderConfig(cfg)
+}
+
+// RenderConfig returns the effective config of the given repo config, which is not read from the config dirs,
+// e.g. the config submitted through the API.
+func (l *Loader) RenderConfig(cfg map[string]any) (map[string]any, error) {
+	res := clone(l.defaults)
+	if ext, ok := cfg[keyExtends]; ok {
+		name, ok := ext.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid extends: %v", ext)
+		}
+		tmpl, err := l.resolveTemplate(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		res = merge(res, tmpl)
+	}
+	res = merge(res, cfg)
+	delete(res, keyExtends)
+	return res, nil
+}
+
+// Load renders the config of the given file and decodes it as a Repo.
+// The returned Repo is not validated.
+func (l *Loader) Load(file string) (*model.Repo, error) {
+	cfg, err := l.Render(file)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRepo(cfg)
+}
+
+// LoadConfig renders the given repo config and decodes it as a Repo.
+// The returned Repo is not validated.
+func (l *Loader) LoadConfig(cfg map[string]any) (*model.Repo, error) {
+	res, err := l.RenderConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRepo(res)
+}
+
+func decodeRepo(cfg map[string]any) (*model.Repo, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var repo model.Repo
+	err = json.Unmarshal(data, &repo)
+	if err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
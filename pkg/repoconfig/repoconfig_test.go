@@ -0,0 +1,136 @@
+package repoconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestLoader(t *testing.T) {
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+	writeFile(t, filepath.Join(dir1, DefaultsFile), `
+defaults:
+  logRotCycle: 10
+  envs:
+    RSYNC_MAXDELETE: "1000"
+templates:
+  rsync:
+    image: ustcmirror/rsync:latest
+    envs:
+      RSYNC_TIMEOUT: "30"
+  rsync-ustc:
+    extends: rsync
+    envs:
+      RSYNC_HOST: rsync.mirrors.ustc.edu.cn
+`)
+	writeFile(t, filepath.Join(dir2, DefaultsFile), `
+defaults:
+  network: host
+`)
+	writeFile(t, filepath.Join(dir1, "debian.yaml"), `
+name: debian
+extends: rsync-ustc
+cron: "0 * * * *"
+storageDir: /tmp
+envs:
+  RSYNC_PATH: debian/
+  RSYNC_TIMEOUT: "60"
+`)
+	writeFile(t, filepath.Join(dir2, "debian.yaml"), `
+logRotCycle: 5
+`)
+
+	l, err := NewLoader([]string{dir1, dir2})
+	require.NoError(t, err)
+	repo, err := l.Load("debian.yaml")
+	require.NoError(t, err)
+	require.Equal(t, &model.Repo{
+		Name:        "debian",
+		Cron:        "0 * * * *",
+		Image:       "ustcmirror/rsync:latest",
+		StorageDir:  "/tmp",
+		Network:     "host",
+		LogRotCycle: 5,
+		Envs: model.StringMap{
+			"RSYNC_MAXDELETE": "1000",
+			"RSYNC_TIMEOUT":   "60",
+			"RSYNC_HOST":      "rsync.mirrors.ustc.edu.cn",
+			"RSYNC_PATH":      "debian/",
+		},
+	}, repo)
+
+	cfg, err := l.Render("debian.yaml")
+	require.NoError(t, err)
+	require.NotContains(t, cfg, "extends")
+
+	// Rendering does not modify the defaults and templates.
+	require.Len(t, l.defaults["envs"], 1)
+	require.Len(t, l.templates["rsync"]["envs"], 1)
+
+	_, err = l.Load("ubuntu.yaml")
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestLoaderByteSize(t *testing.T) {
+	l, err := NewLoader(nil)
+	require.NoError(t, err)
+	for value, expected := range map[any]model.ByteSize{
+		"512m":  512 << 20,
+		"4GiB":  4 << 30,
+		"1.5g":  3 << 29,
+		"2 kb":  2 << 10,
+		1024:    1024,
+		"0.5k":  512,
+		"1024":  1024,
+		"8191p": 8191 << 50,
+	} {
+		repo, err := l.LoadConfig(map[string]any{"memory": value})
+		require.NoError(t, err, "%v", value)
+		require.Equal(t, expected, repo.Memory, "%v", value)
+	}
+	for _, value := range []any{"8192p", "9000000t", 1e19, "1.5.3g", "g", "-1g", "1e3", "4x"} {
+		_, err := l.LoadConfig(map[string]any{"memory": value})
+		require.Error(t, err, "%v", value)
+	}
+}
+
+func TestLoaderTemplateErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, DefaultsFile), `
+templates:
+  a:
+    extends: b
+  b:
+    extends: a
+`)
+	writeFile(t, filepath.Join(dir, "circular.yaml"), "extends: a")
+	writeFile(t, filepath.Join(dir, "unknown.yaml"), "extends: c")
+
+	l, err := NewLoader([]string{dir})
+	require.NoError(t, err)
+	_, err = l.Load("circular.yaml")
+	require.ErrorContains(t, err, "circular template")
+	_, err = l.Load("unknown.yaml")
+	require.ErrorContains(t, err, `unknown template: "c"`)
+
+	writeFile(t, filepath.Join(dir, DefaultsFile), "defaults: [")
+	_, err = NewLoader([]string{dir})
+	require.Error(t, err)
+}
+
+func TestIsConfigFile(t *testing.T) {
+	require.True(t, IsConfigFile("debian.yaml"))
+	require.False(t, IsConfigFile(DefaultsFile))
+	require.False(t, IsConfigFile(".debian.yaml"))
+	require.False(t, IsConfigFile("debian.yml"))
+}
@@ -0,0 +1,63 @@
+package repoconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/robfig/cron/v3"
+
+	"github.com/ustclug/Yuki/pkg/docker"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+// IsValidRepoName reports whether the name can be safely joined with a directory by filepath.Join.
+func IsValidRepoName(name string) bool {
+	return len(name) > 0 && !strings.Contains(name, "/") && name != "." && name != ".."
+}
+
+// NewValidator returns a validator with the custom validations used by the repo configs.
+func NewValidator() *validator.Validate {
+	validate := validator.New()
+	_ = validate.RegisterValidation("repo-name", func(fl validator.FieldLevel) bool {
+		// Avoid possible issues when using filepath.Join with repo name
+		return IsValidRepoName(fl.Field().String())
+	})
+	return validate
+}
+
+// ValidationError is returned by Validate.
+type ValidationError struct {
+	// Kind is what is invalid. One of "config", "image" and "cron".
+	Kind  string
+	Value string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s: %q: %v", e.Kind, e.Value, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate runs the struct validation and checks the image and cron of the repo.
+// src is the origin of the repo used in error messages. The schedule of the repo is returned.
+func Validate(validate func(any) error, repo *model.Repo, src string) (cron.Schedule, error) {
+	err := validate(repo)
+	if err != nil {
+		return nil, &ValidationError{Kind: "config", Value: src, Err: err}
+	}
+
+	_, err = docker.ParseImageRef(repo.Image)
+	if err != nil {
+		return nil, &ValidationError{Kind: "image", Value: repo.Image, Err: err}
+	}
+
+	schedule, err := cron.ParseStandard(repo.Cron)
+	if err != nil {
+		return nil, &ValidationError{Kind: "cron", Value: repo.Cron, Err: err}
+	}
+	return schedule, nil
+}
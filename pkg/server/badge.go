@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const (
+	// badgeStyleFlatSquare is the style without rounded corners and gradients. The default style is "flat".
+	badgeStyleFlatSquare = "flat-square"
+	// badgeMaxAge is how long the badges may be cached by the clients and proxies.
+	badgeMaxAge = time.Minute
+)
+
+// The colors of the badges, taken from shields.io.
+const (
+	badgeColorGreen  = "#4c1"
+	badgeColorYellow = "#dfb317"
+	badgeColorRed    = "#e05d44"
+	badgeColorBlue   = "#007ec6"
+	badgeColorGrey   = "#9f9f9f"
+)
+
+// badge is the data of badgeTemplate. The widths and the positions are in pixels.
+type badge struct {
+	Label        string
+	Message      string
+	Color        string
+	Flat         bool
+	Width        int
+	LabelWidth   int
+	MessageWidth int
+	LabelX       int
+	MessageX     int
+}
+
+var badgeTemplate = template.Must(template.New("badge").Funcs(template.FuncMap{
+	"escape": html.EscapeString,
+}).Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{escape .Label}}: {{escape .Message}}">
+<title>{{escape .Label}}: {{escape .Message}}</title>
+{{- if .Flat}}
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+{{- end}}
+<g{{if .Flat}} clip-path="url(#r)"{{else}} shape-rendering="crispEdges"{{end}}>
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>
+{{- if .Flat}}
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+{{- end}}
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+{{- if .Flat}}
+<text x="{{.LabelX}}" y="15" fill="#010101" fill-opacity=".3">{{escape .Label}}</text>
+{{- end}}
+<text x="{{.LabelX}}" y="14">{{escape .Label}}</text>
+{{- if .Flat}}
+<text x="{{.MessageX}}" y="15" fill="#010101" fill-opacity=".3">{{escape .Message}}</text>
+{{- end}}
+<text x="{{.MessageX}}" y="14">{{escape .Message}}</text>
+</g>
+</svg>
+`))
+
+// badgeTextWidth estimates the width of the text in 11px Verdana with the paddings.
+func badgeTextWidth(s string) int {
+	return utf8.RuneCountInString(s)*7 + 10
+}
+
+// shortAge formats the duration like "5m ago", "2h ago" or "3d ago".
+func shortAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+	}
+}
+
+// badgeMessage returns the message and the color of the badge of the repo.
+func badgeMessage(meta api.GetRepoMetaResponse, now time.Time) (string, string) {
+	switch repoState(meta) {
+	case repoStatePaused:
+		return "paused", badgeColorGrey
+	case repoStateSyncing:
+		return "syncing", badgeColorBlue
+	case repoStateFailed:
+		return "failing", badgeColorRed
+	case repoStateSuccess:
+		msg := "synced " + shortAge(now.Sub(time.Unix(meta.LastSuccess, 0)))
+		if meta.Stale {
+			return msg, badgeColorYellow
+		}
+		return msg, badgeColorGreen
+	default:
+		return "never synced", badgeColorGrey
+	}
+}
+
+func renderBadge(label, message, color, style string) ([]byte, error) {
+	b := badge{
+		Label:        label,
+		Message:      message,
+		Color:        color,
+		Flat:         style != badgeStyleFlatSquare,
+		LabelWidth:   badgeTextWidth(label),
+		MessageWidth: badgeTextWidth(message),
+	}
+	b.Width = b.LabelWidth + b.MessageWidth
+	b.LabelX = b.LabelWidth / 2
+	b.MessageX = b.LabelWidth + b.MessageWidth/2
+	var buf bytes.Buffer
+	err := badgeTemplate.Execute(&buf, b)
+	return buf.Bytes(), err
+}
+
+func (s *Server) handlerGetRepoBadge(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+	var req api.GetRepoBadgeRequest
+	err = (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid query: %v", err))
+	}
+	err = c.Validate(&req)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, err.Error())
+	}
+	label := req.Label
+	if len(label) == 0 {
+		label = name
+	}
+
+	var meta model.RepoMeta
+	res := s.getDB(c).
+		Where(model.RepoMeta{
+			Name: name,
+		}).
+		Limit(1).
+		Find(&meta)
+	if res.Error != nil {
+		const msg = "Fail to get RepoMeta"
+		l.Error(msg, slogErrAttr(res.Error))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	// The badge is still rendered for the unknown repos so that the pages embedding it are not broken.
+	code := http.StatusOK
+	message, color := "not found", badgeColorGrey
+	if res.RowsAffected == 0 {
+		code = http.StatusNotFound
+	} else {
+		message, color = badgeMessage(s.convertModelRepoMetaToGetMetaResponse(meta), time.Now())
+	}
+
+	data, err := renderBadge(label, message, color, req.Style)
+	if err != nil {
+		const msg = "Fail to render badge"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	h := c.Response().Header()
+	h.Set(echo.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(badgeMaxAge.Seconds())))
+	h.Set("ETag", etag)
+	if code == http.StatusOK && c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(code, "image/svg+xml", data)
+}
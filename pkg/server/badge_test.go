@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func TestBadgeMessage(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	testCases := map[string]struct {
+		meta    api.GetRepoMetaResponse
+		message string
+		color   string
+	}{
+		"synced": {
+			meta:    api.GetRepoMetaResponse{LastSuccess: now.Unix() - 7200},
+			message: "synced 2h ago",
+			color:   badgeColorGreen,
+		},
+		"stale": {
+			meta:    api.GetRepoMetaResponse{LastSuccess: now.Unix() - 3*86400, Stale: true},
+			message: "synced 3d ago",
+			color:   badgeColorYellow,
+		},
+		"failing": {
+			meta:    api.GetRepoMetaResponse{LastSuccess: now.Unix() - 7200, ExitCode: 1},
+			message: "failing",
+			color:   badgeColorRed,
+		},
+		"syncing": {
+			meta:    api.GetRepoMetaResponse{Syncing: true},
+			message: "syncing",
+			color:   badgeColorBlue,
+		},
+		"paused": {
+			meta:    api.GetRepoMetaResponse{Paused: true, Syncing: true},
+			message: "paused",
+			color:   badgeColorGrey,
+		},
+		"new": {
+			message: "never synced",
+			color:   badgeColorGrey,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			message, color := badgeMessage(tc.meta, now)
+			require.Equal(t, tc.message, message)
+			require.Equal(t, tc.color, color)
+		})
+	}
+}
+
+func TestHandlerGetRepoBadge(t *testing.T) {
+	te := NewTestEnv(t)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{
+		{Name: "ubuntu", LastSuccess: time.Now().Unix() - 300},
+		{Name: "debian", ExitCode: 1},
+	}).Error)
+	cli := te.RESTClient()
+
+	resp, err := cli.R().Get("/metas/ubuntu/badge.svg")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+	require.Equal(t, "public, max-age=60", resp.Header().Get("Cache-Control"))
+	require.Contains(t, resp.String(), "<title>ubuntu: synced 5m ago</title>")
+	require.Contains(t, resp.String(), `rx="3"`)
+
+	etag := resp.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	resp, err = cli.R().SetHeader("If-None-Match", etag).Get("/metas/ubuntu/badge.svg")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotModified, resp.StatusCode())
+
+	resp, err = cli.R().
+		SetQueryParam("style", "flat-square").
+		SetQueryParam("label", "<debian>").
+		Get("/metas/debian/badge.svg")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.Contains(t, resp.String(), "<title>&lt;debian&gt;: failing</title>")
+	require.Contains(t, resp.String(), badgeColorRed)
+	require.NotContains(t, resp.String(), `rx="3"`)
+
+	resp, err = cli.R().SetQueryParam("style", "plastic").Get("/metas/debian/badge.svg")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = cli.R().Get("/metas/unknown/badge.svg")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode())
+	require.Contains(t, resp.String(), "<title>unknown: not found</title>")
+}
@@ -7,21 +7,105 @@ import (
 )
 
 type Config struct {
-	Debug                 bool          `mapstructure:"debug"`
-	DbURL                 string        `mapstructure:"db_url" validate:"required"`
-	FileSystem            string        `mapstructure:"fs" validate:"oneof=xfs zfs default"`
-	DockerEndpoint        string        `mapstructure:"docker_endpoint" validate:"unix_addr|tcp_addr"`
-	Owner                 string        `mapstructure:"owner"`
-	LogFile               string        `mapstructure:"log_file" validate:"filepath"`
-	RepoLogsDir           string        `mapstructure:"repo_logs_dir" validate:"dir"`
-	RepoConfigDir         []string      `mapstructure:"repo_config_dir" validate:"required,dive,dir"`
-	LogLevel              string        `mapstructure:"log_level" validate:"oneof=debug info warn error"`
-	ListenAddr            string        `mapstructure:"listen_addr" validate:"hostname_port"`
-	BindIP                string        `mapstructure:"bind_ip" validate:"omitempty,ip"`
-	NamePrefix            string        `mapstructure:"name_prefix"`
-	PostSync              []string      `mapstructure:"post_sync"`
-	ImagesUpgradeInterval time.Duration `mapstructure:"images_upgrade_interval" validate:"min=0"`
-	SyncTimeout           time.Duration `mapstructure:"sync_timeout" validate:"min=0"`
+	Debug                 bool             `mapstructure:"debug"`
+	DbURL                 string           `mapstructure:"db_url" validate:"required"`
+	FileSystem            string           `mapstructure:"fs" validate:"oneof=xfs zfs default"`
+	DockerEndpoint        string           `mapstructure:"docker_endpoint" validate:"unix_addr|tcp_addr"`
+	Owner                 string           `mapstructure:"owner"`
+	LogFile               string           `mapstructure:"log_file" validate:"filepath"`
+	RepoLogsDir           string           `mapstructure:"repo_logs_dir" validate:"dir"`
+	RepoConfigDir         []string         `mapstructure:"repo_config_dir" validate:"required,dive,dir"`
+	LogLevel              string           `mapstructure:"log_level" validate:"oneof=debug info warn error"`
+	ListenAddr            string           `mapstructure:"listen_addr" validate:"hostname_port"`
+	BindIP                string           `mapstructure:"bind_ip" validate:"omitempty,ip"`
+	NamePrefix            string           `mapstructure:"name_prefix"`
+	PostSync              []string         `mapstructure:"post_sync"`
+	ImagesUpgradeInterval time.Duration    `mapstructure:"images_upgrade_interval" validate:"min=0"`
+	SyncTimeout           time.Duration    `mapstructure:"sync_timeout" validate:"min=0"`
+	MaxStaleness          time.Duration    `mapstructure:"max_staleness" validate:"min=0"`
+	MaxConcurrentSyncs    int              `mapstructure:"max_concurrent_syncs" validate:"min=0"`
+	ConcurrencyGroups     map[string]int   `mapstructure:"concurrency_groups" validate:"dive,min=1"`
+	Tokens                []TokenConfig    `mapstructure:"tokens" validate:"dive"`
+	Notifiers             []NotifierConfig `mapstructure:"notifiers" validate:"dive"`
+	WatchRepoConfig       bool             `mapstructure:"watch_repo_config"`
+	WatchDebounce         time.Duration    `mapstructure:"watch_debounce" validate:"min=0"`
+	StrictRepoConfig      string           `mapstructure:"strict_repo_config" validate:"oneof=off warn error"`
+	ContainerSecurity     SecurityConfig   `mapstructure:"container_security"`
+	RegistryAuthFile      string           `mapstructure:"registry_auth_file" validate:"omitempty,file"`
+	RegistryAuths         []RegistryAuth   `mapstructure:"registry_auths" validate:"dive"`
+	Mirrorz               MirrorzConfig    `mapstructure:"mirrorz"`
+	TunasyncJobs          bool             `mapstructure:"tunasync_jobs"`
+	StatusTemplateDir     string           `mapstructure:"status_template_dir" validate:"omitempty,dir"`
+}
+
+// MirrorzConfig is the site information served by /api/v1/mirrorz.
+type MirrorzConfig struct {
+	// URL is the base URL of the mirror site. /api/v1/mirrorz is disabled if it is empty.
+	URL          string `mapstructure:"url" validate:"omitempty,http_url"`
+	Abbr         string `mapstructure:"abbr" validate:"required_with=URL"`
+	Name         string `mapstructure:"name"`
+	Logo         string `mapstructure:"logo"`
+	LogoDarkmode string `mapstructure:"logo_darkmode"`
+	Homepage     string `mapstructure:"homepage"`
+	Issue        string `mapstructure:"issue"`
+	Request      string `mapstructure:"request"`
+	Email        string `mapstructure:"email"`
+	Group        string `mapstructure:"group"`
+	Disk         string `mapstructure:"disk"`
+	Note         string `mapstructure:"note"`
+	Big          string `mapstructure:"big"`
+	Banner       string `mapstructure:"banner"`
+}
+
+// RegistryAuth is the credential used to pull the images from a registry.
+type RegistryAuth struct {
+	Host          string `mapstructure:"host" validate:"required"`
+	Username      string `mapstructure:"username" validate:"required_without=IdentityToken"`
+	Password      string `mapstructure:"password"`
+	IdentityToken string `mapstructure:"identity_token"`
+}
+
+// SecurityConfig is the default model.SecurityOptions of all sync containers.
+type SecurityConfig struct {
+	ReadOnlyRootfs  bool     `mapstructure:"read_only_rootfs"`
+	CapDrop         []string `mapstructure:"cap_drop"`
+	CapAdd          []string `mapstructure:"cap_add"`
+	NoNewPrivileges bool     `mapstructure:"no_new_privileges"`
+	SeccompProfile  string   `mapstructure:"seccomp_profile"`
+	AppArmorProfile string   `mapstructure:"apparmor_profile"`
+	Tmpfs           []string `mapstructure:"tmpfs" validate:"dive,startswith=/"`
+}
+
+// TokenConfig is a bearer token that grants access to the private APIs.
+type TokenConfig struct {
+	Name string `mapstructure:"name" validate:"required"`
+	// Hash is the hex-encoded SHA-256 digest of the token.
+	Hash   string   `mapstructure:"hash" validate:"required,sha256"`
+	Scopes []string `mapstructure:"scopes" validate:"required,dive,oneof=read-meta sync reload admin"`
+}
+
+// NotifierConfig is a webhook that is called when the result of a sync matches the filters.
+type NotifierConfig struct {
+	Name   string `mapstructure:"name" validate:"required"`
+	URL    string `mapstructure:"url" validate:"required,http_url"`
+	Method string `mapstructure:"method" validate:"omitempty,oneof=GET POST PUT PATCH"`
+	// Headers are the extra HTTP headers of the request.
+	Headers map[string]string `mapstructure:"headers"`
+	// Body is a text/template that renders the request body with api.WebhookPayload.
+	// The payload encoded as JSON is sent if it is empty.
+	Body string `mapstructure:"body"`
+	// On is the list of events that trigger the webhook.
+	On []string `mapstructure:"on" validate:"required,dive,oneof=failure recovery timeout stale fresh"`
+	// FailureThreshold is the number of consecutive failures required to trigger failure and timeout events.
+	FailureThreshold int `mapstructure:"failure_threshold" validate:"min=0"`
+	// Repos limits the webhook to the given repos. Empty means all repos.
+	Repos []string `mapstructure:"repos"`
+	// Timeout is the timeout of each request.
+	Timeout time.Duration `mapstructure:"timeout" validate:"min=0"`
+	// MaxAttempts is the maximum number of requests sent for each notification.
+	MaxAttempts int `mapstructure:"max_attempts" validate:"min=0"`
+	// RetryInterval is the delay before the first retry. It is doubled after each retry.
+	RetryInterval time.Duration `mapstructure:"retry_interval" validate:"min=0"`
 }
 
 func defaultDockerSocketLocation() string {
@@ -42,4 +126,5 @@ var DefaultConfig = Config{
 	NamePrefix:            "syncing-",
 	LogLevel:              "info",
 	ImagesUpgradeInterval: time.Hour,
+	StrictRepoConfig:      strictWarn,
 }
@@ -20,9 +20,35 @@ db_url = ":memory:"
 repo_logs_dir = "/tmp"
 repo_config_dir = "/tmp"
 sync_timeout = "15s"
+max_concurrent_syncs = 4
+concurrency_groups = { Rsync-USTC = 2 }
+tokens = [
+  { name = "ci", hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", scopes = ["sync", "reload"] },
+]
+registry_auths = [
+  { host = "registry.example.com", username = "bot", password = "secret" },
+]
+container_security = { read_only_rootfs = true, cap_drop = ["ALL"], tmpfs = ["/run:size=16m"] }
+notifiers = [
+  { name = "chat", url = "https://example.com/hook", on = ["failure", "recovery"], failure_threshold = 3, timeout = "5s", headers = { Authorization = "Bearer x" } },
+]
 `)
 	srv, err := New(tmp.Name())
 	require.NoError(t, err)
 	require.Equal(t, time.Second*15, srv.config.SyncTimeout)
 	require.Equal(t, "/tmp", srv.config.RepoConfigDir[0])
+	require.Equal(t, 4, srv.config.MaxConcurrentSyncs)
+	require.Equal(t, map[string]int{"rsync-ustc": 2}, srv.config.ConcurrencyGroups)
+	require.Len(t, srv.config.Tokens, 1)
+	require.Equal(t, []string{"sync", "reload"}, srv.config.Tokens[0].Scopes)
+	require.Len(t, srv.config.Notifiers, 1)
+	require.Equal(t, 3, srv.config.Notifiers[0].FailureThreshold)
+	require.Equal(t, time.Second*5, srv.config.Notifiers[0].Timeout)
+	require.Equal(t, "Bearer x", srv.config.Notifiers[0].Headers["authorization"])
+	require.Equal(t, strictWarn, srv.config.StrictRepoConfig)
+	require.True(t, srv.config.ContainerSecurity.ReadOnlyRootfs)
+	require.Equal(t, []string{"ALL"}, srv.config.ContainerSecurity.CapDrop)
+	require.Equal(t, []string{"/run:size=16m"}, srv.config.ContainerSecurity.Tmpfs)
+	require.Equal(t, []RegistryAuth{{Host: "registry.example.com", Username: "bot", Password: "secret"}}, srv.config.RegistryAuths)
+	require.Len(t, srv.notifiers, 1)
 }
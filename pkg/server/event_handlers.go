@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+)
+
+const sseKeepAliveInterval = 30 * time.Second
+
+func (s *Server) handlerStreamEvents(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	var since uint64
+	lastID := c.Request().Header.Get("Last-Event-ID")
+	if len(lastID) == 0 {
+		lastID = c.QueryParam("since")
+	}
+	if len(lastID) > 0 {
+		var err error
+		since, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid event id: %q", lastID))
+		}
+	}
+	// The error messages may contain internal details, so they are only shown to the clients with scope read-meta.
+	verbose := len(s.config.Tokens) == 0
+	if len(c.Request().Header.Get(echo.HeaderAuthorization)) > 0 {
+		err := s.checkScope(c, scopeReadMeta)
+		if err != nil {
+			return err
+		}
+		verbose = true
+	}
+	stream := c.QueryParam("stream") != "false"
+	repos := c.QueryParams()["repo"]
+
+	backlog, sub := s.events.subscribe(since, repos)
+	defer s.events.unsubscribe(sub)
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+
+	write := func(e api.Event) error {
+		if !verbose {
+			e.Error = ""
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(resp, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		if err != nil {
+			return err
+		}
+		resp.Flush()
+		return nil
+	}
+	for _, e := range backlog {
+		if err := write(e); err != nil {
+			return nil
+		}
+	}
+	resp.Flush()
+	if !stream {
+		return nil
+	}
+
+	ctx, cancel := s.streamContext(c)
+	defer cancel()
+	ticker := time.NewTicker(sseKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-sub.ch:
+			if err := write(e); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(resp, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			resp.Flush()
+		}
+	}
+}
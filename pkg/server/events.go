@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ustclug/Yuki/pkg/api"
+)
+
+const (
+	eventHistorySize   = 100
+	eventSubscriberBuf = 64
+)
+
+type eventSubscriber struct {
+	ch    chan api.Event
+	repos map[string]struct{}
+}
+
+func (sub *eventSubscriber) accept(e api.Event) bool {
+	if len(sub.repos) == 0 {
+		return true
+	}
+	_, ok := sub.repos[e.Repo]
+	return ok
+}
+
+// eventBus broadcasts the sync lifecycle events to the subscribers.
+// The latest events are kept so that clients can catch up after reconnecting.
+type eventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []api.Event
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		nextID:      1,
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// publish sends the event to all subscribers. Events are dropped for slow subscribers.
+func (b *eventBus) publish(e api.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.ID = b.nextID
+	b.nextID++
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+	for sub := range b.subscribers {
+		if !sub.accept(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns the kept events whose ID is greater than since, and a subscriber for the new events.
+// Only the events of the given repos are returned if repos is not empty.
+func (b *eventBus) subscribe(since uint64, repos []string) ([]api.Event, *eventSubscriber) {
+	sub := &eventSubscriber{
+		ch: make(chan api.Event, eventSubscriberBuf),
+	}
+	if len(repos) > 0 {
+		sub.repos = make(map[string]struct{}, len(repos))
+		for _, r := range repos {
+			sub.repos[r] = struct{}{}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var backlog []api.Event
+	for _, e := range b.history {
+		if e.ID > since && sub.accept(e) {
+			backlog = append(backlog, e)
+		}
+	}
+	b.subscribers[sub] = struct{}{}
+	return backlog, sub
+}
+
+func (b *eventBus) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, sub)
+}
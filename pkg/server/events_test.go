@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+)
+
+func TestEventBus(t *testing.T) {
+	b := newEventBus()
+	b.publish(api.Event{Type: api.EventRepoAdded, Repo: "a"})
+	b.publish(api.Event{Type: api.EventRepoAdded, Repo: "b"})
+
+	backlog, sub := b.subscribe(0, nil)
+	require.Len(t, backlog, 2)
+	require.EqualValues(t, 1, backlog[0].ID)
+	require.EqualValues(t, 2, backlog[1].ID)
+	require.NotZero(t, backlog[0].Time)
+
+	backlog, filtered := b.subscribe(1, []string{"a"})
+	require.Empty(t, backlog)
+
+	b.publish(api.Event{Type: api.EventRepoRemoved, Repo: "a"})
+	b.publish(api.Event{Type: api.EventRepoRemoved, Repo: "b"})
+	require.Len(t, sub.ch, 2)
+	require.Len(t, filtered.ch, 1)
+	e := <-filtered.ch
+	require.Equal(t, "a", e.Repo)
+	require.EqualValues(t, 3, e.ID)
+
+	b.unsubscribe(sub)
+	b.publish(api.Event{Type: api.EventRepoAdded, Repo: "c"})
+	require.Len(t, sub.ch, 2)
+
+	for i := 0; i < eventHistorySize; i++ {
+		b.publish(api.Event{Type: api.EventRepoAdded})
+	}
+	backlog, _ = b.subscribe(0, nil)
+	require.Len(t, backlog, eventHistorySize)
+}
+
+func readSSEEvents(t *testing.T, body string) []api.Event {
+	var events []api.Event
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e api.Event
+		require.NoError(t, json.Unmarshal([]byte(data), &e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestHandlerStreamEvents(t *testing.T) {
+	te := NewTestEnv(t)
+	te.server.events.publish(api.Event{Type: api.EventRepoAdded, Repo: "repo0"})
+	te.server.events.publish(api.Event{Type: api.EventRepoAdded, Repo: "repo1"})
+	te.server.events.publish(api.Event{Type: api.EventRepoRemoved, Repo: "repo0"})
+
+	t.Run("backlog", func(t *testing.T) {
+		resp, err := te.RESTClient().R().
+			SetQueryParam("stream", "false").
+			SetQueryParam("repo", "repo0").
+			Get("/events")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		require.Equal(t, "text/event-stream", resp.Header().Get("Content-Type"))
+		events := readSSEEvents(t, string(resp.Body()))
+		require.Len(t, events, 2)
+		require.Equal(t, api.EventRepoAdded, events[0].Type)
+		require.Equal(t, api.EventRepoRemoved, events[1].Type)
+	})
+
+	t.Run("last event id", func(t *testing.T) {
+		resp, err := te.RESTClient().R().
+			SetQueryParam("stream", "false").
+			SetHeader("Last-Event-ID", "2").
+			Get("/events")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		events := readSSEEvents(t, string(resp.Body()))
+		require.Len(t, events, 1)
+		require.EqualValues(t, 3, events[0].ID)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		resp, err := te.RESTClient().R().SetQueryParam("since", "abc").Get("/events")
+		require.NoError(t, err)
+		require.Equal(t, 400, resp.StatusCode())
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		resp, err := te.RESTClient().
+			SetTimeout(5*time.Second).
+			R().
+			SetDoNotParseResponse(true).
+			SetQueryParam("since", "3").
+			Get("/events")
+		require.NoError(t, err)
+		body := resp.RawBody()
+		defer body.Close()
+
+		te.server.events.publish(api.Event{Type: api.EventSyncScheduled, Repo: "repo1", NextRun: 100})
+		scanner := bufio.NewScanner(body)
+		var data string
+		for scanner.Scan() {
+			if v, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				data = v
+				break
+			}
+		}
+		require.NotEmpty(t, data)
+		var e api.Event
+		require.NoError(t, json.Unmarshal([]byte(data), &e))
+		require.Equal(t, api.EventSyncScheduled, e.Type)
+		require.EqualValues(t, 100, e.NextRun)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		te.server.events.publish(api.Event{Type: api.EventImagesUpgraded, Error: "pull image: 10.0.0.1 refused"})
+		te.server.config.Tokens = []TokenConfig{{
+			Name:   "reader",
+			Hash:   hashToken("reader-token"),
+			Scopes: []string{scopeReadMeta},
+		}}
+		t.Cleanup(func() {
+			te.server.config.Tokens = nil
+		})
+		lastError := func(token string) (int, string) {
+			req := te.RESTClient().R().SetQueryParam("stream", "false")
+			if len(token) > 0 {
+				req.SetAuthToken(token)
+			}
+			resp, err := req.Get("/events")
+			require.NoError(t, err)
+			events := readSSEEvents(t, string(resp.Body()))
+			if len(events) == 0 {
+				return resp.StatusCode(), ""
+			}
+			return resp.StatusCode(), events[len(events)-1].Error
+		}
+
+		// The error messages are hidden from the anonymous clients.
+		code, msg := lastError("")
+		require.Equal(t, http.StatusOK, code)
+		require.Empty(t, msg)
+		code, msg = lastError("reader-token")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "pull image: 10.0.0.1 refused", msg)
+		code, _ = lastError("foo")
+		require.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		resp, err := te.RESTClient().
+			SetTimeout(5 * time.Second).
+			R().
+			SetDoNotParseResponse(true).
+			Get("/events")
+		require.NoError(t, err)
+		body := resp.RawBody()
+		defer body.Close()
+
+		te.server.stopStreams()
+		_, err = io.Copy(io.Discard, body)
+		require.NoError(t, err, "Stream is not closed on shutdown")
+	})
+}
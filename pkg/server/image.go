@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/docker"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+// resolveImage returns the image ref used by the next sync of the repo.
+// During a canary rollout, the canary repo uses the candidate while the other repos stay on the stable image.
+func (s *Server) resolveImage(db *gorm.DB, repo *model.Repo) (string, error) {
+	if docker.IsPinned(repo.Image) {
+		return repo.Image, nil
+	}
+	var rollout model.ImageRollout
+	res := db.Where(model.ImageRollout{Image: repo.Image}).Limit(1).Find(&rollout)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repo.Image, nil
+	}
+	if rollout.Status == model.RolloutCanary && rollout.CanaryRepo == repo.Name {
+		return rollout.Candidate, nil
+	}
+	return rollout.Stable, nil
+}
+
+// upgradeImages pulls the images used by the repos periodically, and then removes the dangling images.
+// The images pinned by digest and the images only used with pullPolicy never are skipped.
+func (s *Server) upgradeImages() {
+	db := s.db
+	logger := s.logger
+	logger.Debug("Upgrading images")
+
+	var repos []model.Repo
+	err := db.Select("name", "image", "pull_policy", "canary").Order("name").Find(&repos).Error
+	if err != nil {
+		logger.Error("Fail to query images", slogErrAttr(err))
+		return
+	}
+	var paused []string
+	err = db.Model(&model.RepoMeta{}).Where("paused = ?", true).Pluck("name", &paused).Error
+	if err != nil {
+		logger.Error("Fail to query paused repos", slogErrAttr(err))
+		return
+	}
+	var images, pinned []string
+	// The canary repo of each image. The first repo in name order wins.
+	canaries := make(map[string]string)
+	for _, repo := range repos {
+		if docker.IsPinned(repo.Image) {
+			if !slices.Contains(pinned, repo.Image) {
+				pinned = append(pinned, repo.Image)
+			}
+			continue
+		}
+		if repo.PullPolicy == docker.PullNever {
+			continue
+		}
+		if !slices.Contains(images, repo.Image) {
+			images = append(images, repo.Image)
+		}
+		// The paused repos do not sync, so they cannot try the upgraded images.
+		if _, ok := canaries[repo.Image]; repo.Canary && !ok && !slices.Contains(paused, repo.Name) {
+			canaries[repo.Image] = repo.Name
+		}
+	}
+	s.reassignRollouts(canaries)
+
+	before := make(map[string]string, len(canaries))
+	for img := range canaries {
+		before[img] = s.imageDigest(logger, img)
+	}
+	pullErr := s.dockerCli.PullImages(images)
+	if pullErr != nil {
+		logger.Error("Fail to upgrade images", slogErrAttr(pullErr))
+	}
+	// Some images may have been upgraded even if the others fail to pull.
+	// startRollout does nothing if the digest of the image is unchanged.
+	for img, repo := range canaries {
+		s.startRollout(img, repo, before[img], s.imageDigest(logger, img))
+	}
+	keep, cleanupErr := s.rolloutDigests()
+	// The images pulled by digest have no tag, so they are listed as dangling as well.
+	for _, ref := range pinned {
+		keep = append(keep, ref)
+		if digest := s.imageDigest(logger, ref); len(digest) > 0 && digest != ref {
+			keep = append(keep, digest)
+		}
+	}
+	if cleanupErr == nil {
+		cleanupErr = s.dockerCli.RemoveDanglingImages(keep)
+	}
+	if cleanupErr != nil {
+		logger.Warn("Fail to remove dangling images", slogErrAttr(cleanupErr))
+	}
+
+	err = errors.Join(pullErr, cleanupErr)
+	s.metrics.observeImageUpgrade(err)
+	event := api.Event{
+		Type:   api.EventImagesUpgraded,
+		Images: images,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	s.events.publish(event)
+}
+
+// reassignRollouts hands the ongoing rollouts over to the current canary repos of their images,
+// in case the canary repos are deleted, paused or no longer canaries. Otherwise the rollouts would never finish.
+// The rollouts are dropped if their images have no canary repo anymore.
+func (s *Server) reassignRollouts(canaries map[string]string) {
+	var rollouts []model.ImageRollout
+	err := s.db.Where(model.ImageRollout{Status: model.RolloutCanary}).Find(&rollouts).Error
+	if err != nil {
+		s.logger.Error("Fail to list ImageRollouts", slogErrAttr(err))
+		return
+	}
+	for _, rollout := range rollouts {
+		canaryRepo := canaries[rollout.Image]
+		if canaryRepo == rollout.CanaryRepo {
+			continue
+		}
+		l := s.logger.With(slog.String("image", rollout.Image), slog.String("repo", rollout.CanaryRepo))
+		if len(canaryRepo) == 0 {
+			l.Warn("No canary repo left. Drop the rollout", slog.String("candidate", rollout.Candidate))
+			err = s.db.Delete(&rollout).Error
+		} else {
+			l.Info("Canary repo changed", slog.String("canary", canaryRepo))
+			err = s.db.Model(&rollout).Update("canary_repo", canaryRepo).Error
+		}
+		if err != nil {
+			l.Error("Fail to update ImageRollout", slogErrAttr(err))
+		}
+	}
+}
+
+// rolloutDigests returns the digests referenced by the rollouts, which must not be removed as dangling images.
+// The stable image is dangling once the tag points to the candidate.
+func (s *Server) rolloutDigests() ([]string, error) {
+	var rollouts []model.ImageRollout
+	err := s.db.Find(&rollouts).Error
+	if err != nil {
+		return nil, fmt.Errorf("list ImageRollouts: %w", err)
+	}
+	digests := make([]string, 0, 2*len(rollouts))
+	for _, r := range rollouts {
+		digests = append(digests, r.Stable, r.Candidate)
+	}
+	return digests, nil
+}
+
+func (s *Server) imageDigest(l *slog.Logger, ref string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	digest, err := s.dockerCli.ImageDigest(ctx, ref)
+	if err != nil {
+		l.Warn("Fail to get image digest", slog.String("image", ref), slogErrAttr(err))
+	}
+	return digest
+}
+
+// startRollout starts a canary rollout if the upgrade changed the digest of the image.
+func (s *Server) startRollout(img, canaryRepo, before, after string) {
+	l := s.logger.With(slog.String("image", img), slog.String("repo", canaryRepo))
+	if len(after) == 0 {
+		return
+	}
+	var rollout model.ImageRollout
+	res := s.db.Where(model.ImageRollout{Image: img}).Limit(1).Find(&rollout)
+	if res.Error != nil {
+		l.Error("Fail to get ImageRollout", slogErrAttr(res.Error))
+		return
+	}
+	if res.RowsAffected > 0 {
+		switch after {
+		case rollout.Candidate:
+			// Still being tried, or already rejected.
+			return
+		case rollout.Stable:
+			// The image is rolled back upstream.
+			err := s.db.Delete(&rollout).Error
+			if err != nil {
+				l.Error("Fail to delete ImageRollout", slogErrAttr(err))
+			}
+			return
+		}
+		// A newer image replaces the candidate, while the other repos stay on the stable image.
+		err := s.db.Model(&rollout).Updates(map[string]any{
+			"candidate":   after,
+			"canary_repo": canaryRepo,
+			"status":      model.RolloutCanary,
+		}).Error
+		if err != nil {
+			l.Error("Fail to update ImageRollout", slogErrAttr(err))
+			return
+		}
+	} else {
+		// There is nothing to roll back to if the image did not exist before.
+		if len(before) == 0 || before == after {
+			return
+		}
+		err := s.db.Create(&model.ImageRollout{
+			Image:      img,
+			Stable:     before,
+			Candidate:  after,
+			CanaryRepo: canaryRepo,
+			Status:     model.RolloutCanary,
+		}).Error
+		if err != nil {
+			l.Error("Fail to create ImageRollout", slogErrAttr(err))
+			return
+		}
+	}
+	l.Info("Canary rollout started", slog.String("candidate", after))
+	s.events.publish(api.Event{
+		Type:   api.EventCanaryStarted,
+		Repo:   canaryRepo,
+		Images: []string{after},
+	})
+}
+
+// finishRollout promotes or rejects the candidate according to the result of the canary sync.
+func (s *Server) finishRollout(record model.SyncRecord) {
+	if record.Cancelled || len(record.ImageDigest) == 0 {
+		return
+	}
+	l := s.logger.With(slog.String("repo", record.Name))
+	var rollout model.ImageRollout
+	res := s.db.
+		Where(model.ImageRollout{
+			CanaryRepo: record.Name,
+			Candidate:  record.ImageDigest,
+			Status:     model.RolloutCanary,
+		}).
+		Limit(1).
+		Find(&rollout)
+	if res.Error != nil {
+		l.Error("Fail to get ImageRollout", slogErrAttr(res.Error))
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+	l = l.With(slog.String("image", rollout.Image), slog.String("candidate", rollout.Candidate))
+	event := api.Event{
+		Repo:   record.Name,
+		Images: []string{rollout.Candidate},
+	}
+	var err error
+	if record.ExitCode == 0 {
+		// The other repos use the tag again, which points to the candidate.
+		err = s.db.Delete(&rollout).Error
+		event.Type = api.EventCanaryPromoted
+		l.Info("Canary image promoted")
+	} else {
+		err = s.db.Model(&rollout).Update("status", model.RolloutFailed).Error
+		event.Type = api.EventCanaryFailed
+		l.Warn("Canary sync failed. Keep using the stable image", slog.String("stable", rollout.Stable))
+	}
+	if err != nil {
+		l.Error("Fail to update ImageRollout", slogErrAttr(err))
+		return
+	}
+	s.events.publish(event)
+}
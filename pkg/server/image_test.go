@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/docker"
+	fakedocker "github.com/ustclug/Yuki/pkg/docker/fake"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func TestCanaryRollout(t *testing.T) {
+	te := NewTestEnv(t)
+	te.server.config.RepoLogsDir = t.TempDir()
+	const img = "ustcmirror/test:latest"
+	digest := func(c string) string {
+		return "ustcmirror/test@sha256:" + strings.Repeat(c, 64)
+	}
+	fakeCli := te.server.dockerCli.(*fakedocker.Client)
+	fakeCli.SetImageDigest(img, digest("0"))
+	var pulled []string
+	next := digest("1")
+	te.server.dockerCli = &fakeImageClient{
+		Client: fakeCli,
+		pullImage: func(ctx context.Context, ref string) error {
+			pulled = append(pulled, ref)
+			fakeCli.SetImageDigest(ref, next)
+			return nil
+		},
+	}
+	storageDir := t.TempDir()
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "canary", Image: img, StorageDir: storageDir, Canary: true},
+		{Name: "repo0", Image: img, StorageDir: storageDir, PullPolicy: docker.PullAlways},
+		{Name: "pinned", Image: digest("f"), StorageDir: storageDir},
+		{Name: "local", Image: "local:latest", StorageDir: storageDir, PullPolicy: docker.PullNever},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{{Name: "canary"}, {Name: "repo0"}}).Error)
+
+	resolve := func(name string) string {
+		var repo model.Repo
+		require.NoError(t, te.server.db.Where(model.Repo{Name: name}).First(&repo).Error)
+		ref, err := te.server.resolveImage(te.server.db, &repo)
+		require.NoError(t, err)
+		return ref
+	}
+	getRollout := func() (model.ImageRollout, bool) {
+		var rollout model.ImageRollout
+		res := te.server.db.Where(model.ImageRollout{Image: img}).Limit(1).Find(&rollout)
+		require.NoError(t, res.Error)
+		return rollout, res.RowsAffected > 0
+	}
+
+	te.server.upgradeImages()
+	// The pinned image and the image with pullPolicy never are not upgraded.
+	require.Equal(t, []string{img}, pulled)
+	rollout, ok := getRollout()
+	require.True(t, ok)
+	require.Equal(t, model.ImageRollout{
+		Image:      img,
+		Stable:     digest("0"),
+		Candidate:  digest("1"),
+		CanaryRepo: "canary",
+		Status:     model.RolloutCanary,
+		CreatedAt:  rollout.CreatedAt,
+		UpdatedAt:  rollout.UpdatedAt,
+	}, rollout)
+	require.Equal(t, digest("1"), resolve("canary"))
+	require.Equal(t, digest("0"), resolve("repo0"))
+	require.Equal(t, digest("f"), resolve("pinned"))
+
+	// The image and the digest actually used are recorded.
+	require.NoError(t, te.server.syncRepo(context.TODO(), "repo0", false, model.SyncTriggerManual))
+	runs := fakeCli.Runs()
+	require.Len(t, runs, 1)
+	require.Equal(t, digest("0"), runs[0].Config.Image)
+	require.Equal(t, docker.PullAlways, runs[0].Config.PullPolicy)
+	var record model.SyncRecord
+	require.NoError(t, te.server.db.Where(model.SyncRecord{Name: "repo0"}).First(&record).Error)
+	require.Equal(t, digest("0"), record.Image)
+	require.Equal(t, digest("0"), record.ImageDigest)
+
+	// A failed canary sync rejects the candidate.
+	te.server.finishRollout(model.SyncRecord{Name: "canary", ImageDigest: digest("1"), ExitCode: 1})
+	rollout, _ = getRollout()
+	require.Equal(t, model.RolloutFailed, rollout.Status)
+	require.Equal(t, digest("0"), resolve("canary"))
+
+	// The rejected candidate is not tried again.
+	te.server.upgradeImages()
+	rollout, _ = getRollout()
+	require.Equal(t, model.RolloutFailed, rollout.Status)
+
+	// A newer image is tried again.
+	next = digest("2")
+	te.server.upgradeImages()
+	rollout, _ = getRollout()
+	require.Equal(t, model.RolloutCanary, rollout.Status)
+	require.Equal(t, digest("0"), rollout.Stable)
+	require.Equal(t, digest("2"), rollout.Candidate)
+
+	// Cancelled syncs and the syncs with other images are ignored.
+	te.server.finishRollout(model.SyncRecord{Name: "canary", ImageDigest: digest("2"), ExitCode: 1, Cancelled: true})
+	te.server.finishRollout(model.SyncRecord{Name: "canary", ImageDigest: digest("0"), ExitCode: 0})
+	rollout, _ = getRollout()
+	require.Equal(t, model.RolloutCanary, rollout.Status)
+
+	// A successful canary sync promotes the candidate.
+	te.server.finishRollout(model.SyncRecord{Name: "canary", ImageDigest: digest("2"), ExitCode: 0})
+	_, ok = getRollout()
+	require.False(t, ok)
+	require.Equal(t, img, resolve("repo0"))
+}
+
+func TestCanaryRolloutCleanupFails(t *testing.T) {
+	te := NewTestEnv(t)
+	const img = "ustcmirror/test:latest"
+	digest := func(c string) string {
+		return "ustcmirror/test@sha256:" + strings.Repeat(c, 64)
+	}
+	fakeCli := te.server.dockerCli.(*fakedocker.Client)
+	fakeCli.SetImageDigest(img, digest("0"))
+	var kept [][]string
+	te.server.dockerCli = &fakeImageClient{
+		Client: fakeCli,
+		pullImage: func(ctx context.Context, ref string) error {
+			fakeCli.SetImageDigest(ref, digest("1"))
+			return nil
+		},
+		removeDanglingImages: func(keep []string) error {
+			kept = append(kept, keep)
+			// The old image is still used by a running sync.
+			return errors.New("image is being used by running container")
+		},
+	}
+	storageDir := t.TempDir()
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "canary", Image: img, StorageDir: storageDir, Canary: true},
+		{Name: "repo0", Image: img, StorageDir: storageDir},
+	}).Error)
+
+	te.server.upgradeImages()
+	var rollout model.ImageRollout
+	require.NoError(t, te.server.db.Where(model.ImageRollout{Image: img}).First(&rollout).Error)
+	require.Equal(t, model.RolloutCanary, rollout.Status)
+	require.Equal(t, digest("0"), rollout.Stable)
+	require.Equal(t, digest("1"), rollout.Candidate)
+
+	// The images of the rollout are not removed as dangling images.
+	require.Len(t, kept, 1)
+	require.ElementsMatch(t, []string{digest("0"), digest("1")}, kept[0])
+
+	events, sub := te.server.events.subscribe(0, nil)
+	te.server.events.unsubscribe(sub)
+	last := events[len(events)-1]
+	require.Equal(t, api.EventImagesUpgraded, last.Type)
+	require.Contains(t, last.Error, "being used")
+}
+
+func TestUpgradeImagesKeepsPinnedImages(t *testing.T) {
+	te := NewTestEnv(t)
+	digest := func(c string) string {
+		return "ustcmirror/test@sha256:" + strings.Repeat(c, 64)
+	}
+	fakeCli := te.server.dockerCli.(*fakedocker.Client)
+	var kept [][]string
+	te.server.dockerCli = &fakeImageClient{
+		Client: fakeCli,
+		pullImage: func(ctx context.Context, ref string) error {
+			return nil
+		},
+		removeDanglingImages: func(keep []string) error {
+			kept = append(kept, keep)
+			return nil
+		},
+	}
+	storageDir := t.TempDir()
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "pinned", Image: digest("e"), StorageDir: storageDir},
+		{Name: "local", Image: digest("f"), StorageDir: storageDir, PullPolicy: docker.PullNever},
+	}).Error)
+
+	te.server.upgradeImages()
+	// The images pulled by digest have no tag, but they are still used by the repos.
+	require.Len(t, kept, 1)
+	require.ElementsMatch(t, []string{digest("e"), digest("f")}, kept[0])
+}
+
+func TestCanaryRolloutCanaryRemoved(t *testing.T) {
+	te := NewTestEnv(t)
+	const img = "ustcmirror/test:latest"
+	digest := func(c string) string {
+		return "ustcmirror/test@sha256:" + strings.Repeat(c, 64)
+	}
+	fakeCli := te.server.dockerCli.(*fakedocker.Client)
+	fakeCli.SetImageDigest(img, digest("1"))
+	te.server.dockerCli = &fakeImageClient{
+		Client: fakeCli,
+		pullImage: func(ctx context.Context, ref string) error {
+			return nil
+		},
+		removeDanglingImages: func(keep []string) error {
+			return nil
+		},
+	}
+	storageDir := t.TempDir()
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "canary", Image: img, StorageDir: storageDir, Canary: true},
+		{Name: "repo0", Image: img, StorageDir: storageDir},
+	}).Error)
+	require.NoError(t, te.server.db.Create(&model.ImageRollout{
+		Image:      img,
+		Stable:     digest("0"),
+		Candidate:  digest("1"),
+		CanaryRepo: "gone",
+		Status:     model.RolloutCanary,
+	}).Error)
+	getRollout := func() (model.ImageRollout, bool) {
+		var rollout model.ImageRollout
+		res := te.server.db.Where(model.ImageRollout{Image: img}).Limit(1).Find(&rollout)
+		require.NoError(t, res.Error)
+		return rollout, res.RowsAffected > 0
+	}
+
+	// The rollout of a deleted canary repo is handed over to another canary repo.
+	te.server.upgradeImages()
+	rollout, ok := getRollout()
+	require.True(t, ok)
+	require.Equal(t, model.RolloutCanary, rollout.Status)
+	require.Equal(t, "canary", rollout.CanaryRepo)
+	require.Equal(t, digest("1"), rollout.Candidate)
+
+	// The rollout is dropped once no canary repo is left.
+	require.NoError(t, te.server.db.Create(&model.RepoMeta{Name: "canary", Paused: true}).Error)
+	te.server.upgradeImages()
+	_, ok = getRollout()
+	require.False(t, ok)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/repoconfig"
+)
+
+const logFollowInterval = time.Second
+
+// repoLogPath joins the log dir of the repo with elem.
+// It rejects the paths escaping repo_logs_dir, since the repo name in the route is not cleaned by echo.
+func (s *Server) repoLogPath(name string, elem ...string) (string, error) {
+	if !repoconfig.IsValidRepoName(name) {
+		return "", newHTTPError(http.StatusBadRequest, "Invalid repo name")
+	}
+	base := filepath.Clean(s.config.RepoLogsDir)
+	p := filepath.Join(append([]string{base, name}, elem...)...)
+	rel, err := filepath.Rel(base, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", newHTTPError(http.StatusBadRequest, "Invalid log path")
+	}
+	return p, nil
+}
+
+func (s *Server) handlerListRepoLogs(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+
+	logDir, err := s.repoLogPath(name)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newHTTPError(http.StatusNotFound, "Log dir not found")
+		}
+		const msg = "Fail to list log files"
+		l.Error(msg, slogErrAttr(err), slog.String("repo", name))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	resp := make(api.ListLogFilesResponse, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		resp = append(resp, api.LogFileInfo{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().Unix(),
+		})
+	}
+	sort.SliceStable(resp, func(i, j int) bool {
+		return resp[i].ModTime > resp[j].ModTime
+	})
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handlerGetRepoLog(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+	file := c.Param("file")
+	if len(file) == 0 || file != filepath.Base(file) || file[0] == '.' {
+		return newHTTPError(http.StatusBadRequest, "Invalid file name")
+	}
+	l = l.With(slog.String("repo", name), slog.String("file", file))
+
+	tail := -1
+	if val := c.QueryParam("tail"); len(val) > 0 {
+		tail, err = strconv.Atoi(val)
+		if err != nil || tail < 0 {
+			return newHTTPError(http.StatusBadRequest, "Invalid tail")
+		}
+	}
+	follow := len(c.QueryParam("follow")) > 0
+	if (tail >= 0 || follow) && strings.HasSuffix(file, ".gz") {
+		return newHTTPError(http.StatusBadRequest, "Cannot tail or follow compressed log")
+	}
+
+	logFile, err := s.repoLogPath(name, file)
+	if err != nil {
+		return err
+	}
+	f, info, err := openLogFile(logFile)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ELOOP) || errors.Is(err, errNotRegularFile) {
+			return newHTTPError(http.StatusNotFound, "Log file not found")
+		}
+		const msg = "Fail to open log file"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	defer f.Close()
+
+	if tail < 0 && !follow {
+		// http.ServeContent handles the Range header for us.
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+		http.ServeContent(c.Response(), c.Request(), file, info.ModTime(), f)
+		return nil
+	}
+
+	var offset int64
+	if tail >= 0 {
+		offset, err = tailOffset(f, info.Size(), tail)
+		if err != nil {
+			const msg = "Fail to read log file"
+			l.Error(msg, slogErrAttr(err))
+			return newHTTPError(http.StatusInternalServerError, msg)
+		}
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		const msg = "Fail to read log file"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	resp.WriteHeader(http.StatusOK)
+	_, err = io.Copy(resp, f)
+	if err != nil || !follow {
+		return nil
+	}
+	resp.Flush()
+
+	ctx, cancel := s.streamContext(c)
+	defer cancel()
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		// Read the remaining content once more after the sync has finished.
+		running := s.queue.isRunning(name)
+		n, err := io.Copy(resp, f)
+		if err != nil {
+			return nil
+		}
+		if n > 0 {
+			resp.Flush()
+		}
+		if !running {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+var errNotRegularFile = errors.New("not a regular file")
+
+// openLogFile opens the log file for reading.
+// Since the log dir is writable by the sync container, symlinks and other non-regular files
+// are rejected so that they cannot expose the files outside the log dir.
+func openLogFile(p string) (*os.File, os.FileInfo, error) {
+	// O_NONBLOCK prevents blocking on FIFOs. It has no effect on regular files.
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, nil, errNotRegularFile
+	}
+	return f, info, nil
+}
+
+// tailOffset returns the offset of the last n lines of the given file.
+func tailOffset(r io.ReaderAt, size int64, n int) (int64, error) {
+	if n == 0 {
+		return size, nil
+	}
+	var buf [4096]byte
+	lines := 0
+	end := size
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		_, err := r.ReadAt(chunk, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			pos := start + int64(i)
+			// The trailing newline does not end another line.
+			if chunk[i] != '\n' || pos == size-1 {
+				continue
+			}
+			lines++
+			if lines == n {
+				return pos + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
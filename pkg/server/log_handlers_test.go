@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	testutils "github.com/ustclug/Yuki/test/utils"
+)
+
+func TestHandlerListRepoLogs(t *testing.T) {
+	te := NewTestEnv(t)
+	te.server.config.RepoLogsDir = t.TempDir()
+	logDir := filepath.Join(te.server.config.RepoLogsDir, "repo0")
+	require.NoError(t, os.MkdirAll(logDir, 0o755))
+	testutils.WriteFile(t, filepath.Join(logDir, "result.log.1.gz"), "old")
+	testutils.WriteFile(t, filepath.Join(logDir, "result.log.0"), "new log")
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(logDir, "result.log.1.gz"), past, past))
+
+	var files api.ListLogFilesResponse
+	resp, err := te.RESTClient().R().SetResult(&files).Get("/repos/repo0/logs")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Len(t, files, 2)
+	require.Equal(t, "result.log.0", files[0].Name)
+	require.EqualValues(t, 7, files[0].Size)
+
+	resp, err = te.RESTClient().R().Get("/repos/nonexist/logs")
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode())
+}
+
+func TestHandlerRepoLogsTraversal(t *testing.T) {
+	te := NewTestEnv(t)
+	parent := t.TempDir()
+	te.server.config.RepoLogsDir = filepath.Join(parent, "logs")
+	require.NoError(t, os.MkdirAll(te.server.config.RepoLogsDir, 0o755))
+	testutils.WriteFile(t, filepath.Join(parent, "secret.log"), "secret")
+	testutils.WriteFile(t, filepath.Join(te.server.config.RepoLogsDir, "secret.log"), "secret")
+
+	cli := te.RESTClient()
+	for _, path := range []string{
+		"/repos/../logs",
+		"/repos/%2E%2E/logs",
+		"/repos/../logs/secret.log",
+		"/repos/%2E%2E/logs/secret.log",
+		"/repos/./logs",
+		"/repos/./logs/secret.log",
+	} {
+		resp, err := cli.R().Get(path)
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "path: %s", path)
+		require.NotContains(t, resp.String(), "secret", "path: %s", path)
+	}
+}
+
+func TestHandlerRepoLogsSymlink(t *testing.T) {
+	te := NewTestEnv(t)
+	parent := t.TempDir()
+	te.server.config.RepoLogsDir = filepath.Join(parent, "logs")
+	logDir := filepath.Join(te.server.config.RepoLogsDir, "repo0")
+	require.NoError(t, os.MkdirAll(logDir, 0o755))
+	testutils.WriteFile(t, filepath.Join(parent, "secret"), "secret")
+	// The sync container may replace its log file with a symlink.
+	require.NoError(t, os.Symlink(filepath.Join(parent, "secret"), filepath.Join(logDir, "result.log")))
+
+	cli := te.RESTClient()
+	for _, query := range []string{"", "tail=1", "follow=1"} {
+		resp, err := cli.R().SetQueryString(query).Get("/repos/repo0/logs/result.log")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode(), "query: %s", query)
+		require.NotContains(t, resp.String(), "secret", "query: %s", query)
+	}
+
+	var files api.ListLogFilesResponse
+	resp, err := cli.R().SetResult(&files).Get("/repos/repo0/logs")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Empty(t, files)
+}
+
+func TestHandlerGetRepoLog(t *testing.T) {
+	te := NewTestEnv(t)
+	te.server.config.RepoLogsDir = t.TempDir()
+	logDir := filepath.Join(te.server.config.RepoLogsDir, "repo0")
+	require.NoError(t, os.MkdirAll(logDir, 0o755))
+	logFile := filepath.Join(logDir, "result.log.0")
+	testutils.WriteFile(t, logFile, "line1\nline2\nline3\n")
+
+	cli := te.RESTClient()
+	t.Run("whole file", func(t *testing.T) {
+		resp, err := cli.R().Get("/repos/repo0/logs/result.log.0")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		require.Equal(t, "line1\nline2\nline3\n", string(resp.Body()))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		resp, err := cli.R().SetHeader("Range", "bytes=6-10").Get("/repos/repo0/logs/result.log.0")
+		require.NoError(t, err)
+		require.Equal(t, 206, resp.StatusCode())
+		require.Equal(t, "line2", string(resp.Body()))
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		resp, err := cli.R().SetQueryParam("tail", "2").Get("/repos/repo0/logs/result.log.0")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		require.Equal(t, "line2\nline3\n", string(resp.Body()))
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		resp, err := cli.R().Get("/repos/repo0/logs/..%2F..%2Fetc%2Fpasswd")
+		require.NoError(t, err)
+		require.Equal(t, 400, resp.StatusCode())
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		te.server.queue.markRunning("repo0", "")
+		go func() {
+			time.Sleep(2 * logFollowInterval)
+			f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0o644)
+			if err == nil {
+				_, _ = f.WriteString("line4\n")
+				_ = f.Close()
+			}
+			te.server.queue.release("repo0")
+		}()
+		resp, err := cli.R().
+			SetQueryParam("tail", "1").
+			SetQueryParam("follow", "true").
+			Get("/repos/repo0/logs/result.log.0")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		require.Equal(t, "line3\nline4\n", string(resp.Body()))
+	})
+}
+
+func TestTailOffset(t *testing.T) {
+	testCases := map[string]struct {
+		content string
+		n       int
+		expect  string
+	}{
+		"trailing newline":    {content: "a\nb\nc\n", n: 2, expect: "b\nc\n"},
+		"no trailing newline": {content: "a\nb\nc", n: 2, expect: "b\nc"},
+		"more than lines":     {content: "a\nb\n", n: 10, expect: "a\nb\n"},
+		"zero":                {content: "a\nb\n", n: 0, expect: ""},
+		"long content":        {content: strings.Repeat("x", 5000) + "\n" + strings.Repeat("y", 5000) + "\n", n: 1, expect: strings.Repeat("y", 5000) + "\n"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := strings.NewReader(tc.content)
+			offset, err := tailOffset(r, int64(len(tc.content)), tc.n)
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, tc.content[offset:])
+		})
+	}
+}
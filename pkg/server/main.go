@@ -4,19 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/glebarez/sqlite"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
+	"github.com/ustclug/Yuki/pkg/api"
 	"github.com/ustclug/Yuki/pkg/docker"
 	"github.com/ustclug/Yuki/pkg/fs"
 	"github.com/ustclug/Yuki/pkg/model"
@@ -24,15 +30,33 @@ import (
 
 type Server struct {
 	repoSchedules cmap.ConcurrentMap[string, cron.Schedule]
+	queue         *syncQueue
+	// cancelledSyncs contains the IDs of the sync containers being cancelled.
+	cancelledSyncs cmap.ConcurrentMap[string, struct{}]
+	// reloadMu serializes the reloads of all repos.
+	reloadMu   sync.Mutex
+	lastReload atomic.Pointer[api.ReloadResult]
 
 	e         *echo.Echo
 	dockerCli docker.Client
 	config    Config
 	db        *gorm.DB
 	logger    *slog.Logger
+	metrics   *metrics
+	events    *eventBus
+	notifiers []*notifier
 	getSize   func(string) int64
+	// statusTmpl renders the status page.
+	statusTmpl *template.Template
+	// streamCtx is cancelled by stopStreams when the HTTP server shuts down,
+	// since http.Server.Shutdown does not cancel the requests of the long-running streams.
+	streamCtx   context.Context
+	stopStreams context.CancelFunc
 }
 
+// shutdownTimeout is how long the HTTP server waits for the in-flight requests on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func New(configPath string) (*Server, error) {
 	v := viper.New()
 	v.SetConfigFile(configPath)
@@ -59,7 +83,18 @@ func NewWithConfig(cfg Config) (*Server, error) {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
 
-	dockerCli, err := docker.NewClient(cfg.DockerEndpoint)
+	creds := docker.Credentials{
+		File:  cfg.RegistryAuthFile,
+		Auths: make(map[string]docker.RegistryAuth, len(cfg.RegistryAuths)),
+	}
+	for _, a := range cfg.RegistryAuths {
+		creds.Auths[a.Host] = docker.RegistryAuth{
+			Username:      a.Username,
+			Password:      a.Password,
+			IdentityToken: a.IdentityToken,
+		}
+	}
+	dockerCli, err := docker.NewClient(cfg.DockerEndpoint, creds)
 	if err != nil {
 		return nil, err
 	}
@@ -83,16 +118,39 @@ func NewWithConfig(cfg Config) (*Server, error) {
 		logLvl = slog.LevelInfo
 	}
 
+	notifiers, err := newNotifiers(cfg.Notifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	statusTmpl, err := newStatusTemplate(cfg.StatusTemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
 	slogger := newSlogger(logfile, cfg.Debug, logLvl)
+	m := newMetrics(db, slogger)
 
 	s := Server{
-		e:             echo.New(),
-		db:            db,
-		logger:        slogger,
-		dockerCli:     dockerCli,
+		e:      echo.New(),
+		db:     db,
+		logger: slogger,
+		dockerCli: &instrumentedDockerClient{
+			Client: dockerCli,
+			errors: m.dockerErrors,
+		},
+		metrics:       m,
+		events:        newEventBus(),
+		notifiers:     notifiers,
 		config:        cfg,
 		repoSchedules: cmap.New[cron.Schedule](),
+		queue:         newSyncQueue(cfg.MaxConcurrentSyncs, cfg.ConcurrencyGroups),
+		statusTmpl:    statusTmpl,
+
+		cancelledSyncs: cmap.New[struct{}](),
 	}
+	s.streamCtx, s.stopStreams = context.WithCancel(context.Background())
+	s.e.Server.RegisterOnShutdown(s.stopStreams)
 	switch cfg.FileSystem {
 	case "zfs":
 		s.getSize = fs.New(fs.ZFS).GetSize
@@ -116,6 +174,7 @@ func NewWithConfig(cfg Config) (*Server, error) {
 		LogStatus:    true,
 		LogLatency:   true,
 		LogUserAgent: true,
+		LogMethod:    true,
 		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
 			attrs := []slog.Attr{
 				slog.Int("status", v.Status),
@@ -124,6 +183,7 @@ func NewWithConfig(cfg Config) (*Server, error) {
 			}
 			l := getLogger(c)
 			l.LogAttrs(context.Background(), slog.LevelDebug, "REQUEST", attrs...)
+			s.metrics.observeRequest(c, v)
 			return nil
 		},
 	}))
@@ -162,6 +222,18 @@ func (s *Server) Start(rootCtx context.Context) error {
 		return fmt.Errorf("wait running containers: %w", err)
 	}
 
+	if len(s.config.Tokens) == 0 {
+		l.Warn("No token is configured. The private APIs are accessible to anyone")
+	}
+
+	if s.config.WatchRepoConfig {
+		l.Info("Watching repo configs")
+		err = s.watchRepoConfigs(ctx)
+		if err != nil {
+			return fmt.Errorf("watch repo configs: %w", err)
+		}
+	}
+
 	l.Info("Scheduling tasks")
 	s.scheduleTasks(ctx)
 
@@ -175,7 +247,12 @@ func (s *Server) Start(rootCtx context.Context) error {
 
 	<-ctx.Done()
 	l.Info("Shutting down HTTP server")
-	_ = s.e.Shutdown(context.Background())
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	err = s.e.Shutdown(shutdownCtx)
+	if err != nil {
+		l.Warn("Fail to shut down HTTP server gracefully", slogErrAttr(err))
+	}
 
 	caused := context.Cause(ctx)
 	if errors.Is(caused, context.Canceled) {
@@ -191,17 +268,37 @@ func (s *Server) ListenAddr() string {
 }
 
 func (s *Server) registerAPIs(e *echo.Echo) {
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})))
+	e.GET("/status", s.handlerStatusPage)
+
 	v1API := e.Group("/api/v1/")
 
 	// public APIs
 	v1API.GET("metas", s.handlerListRepoMetas)
 	v1API.GET("metas/:name", s.handlerGetRepoMeta)
+	v1API.GET("metas/:name/badge.svg", s.handlerGetRepoBadge)
+	v1API.GET("events", s.handlerStreamEvents)
+	v1API.GET("mirrorz", s.handlerGetMirrorz)
+	v1API.GET("tunasync/jobs", s.handlerListTunasyncJobs)
 
 	// private APIs
-	v1API.GET("repos", s.handlerListRepos)
-	v1API.GET("repos/:name", s.handlerGetRepo)
-	v1API.DELETE("repos/:name", s.handlerRemoveRepo)
-	v1API.POST("repos/:name", s.handlerReloadRepo)
-	v1API.POST("repos", s.handlerReloadAllRepos)
-	v1API.POST("repos/:name/sync", s.handlerSyncRepo)
+	readMetaScope := s.requireScope(scopeReadMeta)
+	syncScope := s.requireScope(scopeSync)
+	reloadScope := s.requireScope(scopeReload)
+	adminScope := s.requireScope(scopeAdmin)
+	v1API.GET("repos", s.handlerListRepos, readMetaScope)
+	v1API.GET("repos/:name", s.handlerGetRepo, readMetaScope)
+	v1API.DELETE("repos/:name", s.handlerRemoveRepo, adminScope)
+	v1API.POST("repos/:name", s.handlerReloadRepo, reloadScope)
+	v1API.PUT("repos/:name", s.handlerApplyRepo, adminScope)
+	v1API.POST("repos", s.handlerReloadAllRepos, reloadScope)
+	v1API.GET("reload", s.handlerGetReloadStatus, readMetaScope)
+	v1API.POST("repos/:name/sync", s.handlerSyncRepo, syncScope)
+	v1API.DELETE("repos/:name/sync", s.handlerCancelSyncRepo, syncScope)
+	v1API.GET("repos/:name/history", s.handlerListRepoHistory, readMetaScope)
+	v1API.GET("repos/:name/logs", s.handlerListRepoLogs, readMetaScope)
+	v1API.GET("repos/:name/logs/:file", s.handlerGetRepoLog, readMetaScope)
+	v1API.POST("repos/:name/pause", s.handlerPauseRepo, adminScope)
+	v1API.POST("repos/:name/resume", s.handlerResumeRepo, adminScope)
+	v1API.GET("notifications", s.handlerListNotificationDeliveries, readMetaScope)
 }
@@ -61,16 +61,26 @@ func NewTestEnv(t *testing.T) *TestEnv {
 	})
 	require.NoError(t, err)
 	require.NoError(t, model.AutoMigrate(db))
+	statusTmpl, err := newStatusTemplate("")
+	require.NoError(t, err)
 
 	s := &Server{
 		e:         e,
 		db:        db,
 		logger:    slogger,
 		dockerCli: fakedocker.NewClient(),
+		metrics:   newMetrics(db, slogger),
+		events:    newEventBus(),
 		getSize:   fs.New(fs.DEFAULT).GetSize,
 
 		repoSchedules: cmap.New[cron.Schedule](),
+		queue:         newSyncQueue(0, nil),
+		statusTmpl:    statusTmpl,
+
+		cancelledSyncs: cmap.New[struct{}](),
 	}
+	s.streamCtx, s.stopStreams = context.WithCancel(context.Background())
+	t.Cleanup(s.stopStreams)
 	s.e.Use(setLogger(slogger))
 	s.e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus: true,
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -13,12 +14,32 @@ func (s *Server) handlerListRepoMetas(c echo.Context) error {
 	l := getLogger(c)
 	l.Debug("Invoked")
 
+	resp, err := s.listRepoMetas(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// listRepoMetas lists the RepoMetas filtered by the query of the request.
+func (s *Server) listRepoMetas(c echo.Context) (api.ListRepoMetasResponse, error) {
+	l := getLogger(c)
+	var req api.ListRepoMetasRequest
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid query: %v", err))
+	}
+
 	var metas []model.RepoMeta
-	err := s.getDB(c).Order("name").Find(&metas).Error
+	db := s.getDB(c)
+	if req.Stale != nil {
+		db = db.Where("stale = ?", *req.Stale)
+	}
+	err = db.Order("name").Find(&metas).Error
 	if err != nil {
 		const msg = "Fail to list RepoMetas"
 		l.Error(msg, slogErrAttr(err))
-		return &echo.HTTPError{
+		return nil, &echo.HTTPError{
 			Code:    http.StatusInternalServerError,
 			Message: msg,
 		}
@@ -27,7 +48,7 @@ func (s *Server) handlerListRepoMetas(c echo.Context) error {
 	for i, meta := range metas {
 		resp[i] = s.convertModelRepoMetaToGetMetaResponse(meta)
 	}
-	return c.JSON(http.StatusOK, resp)
+	return resp, nil
 }
 
 func (s *Server) handlerGetRepoMeta(c echo.Context) error {
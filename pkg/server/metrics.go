@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/docker"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const metricsNamespace = "yuki"
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	syncRuns            *prometheus.CounterVec
+	syncDuration        *prometheus.HistogramVec
+	dockerErrors        *prometheus.CounterVec
+	imageUpgrades       *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+}
+
+func newMetrics(db *gorm.DB, logger *slog.Logger) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		syncRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sync_runs_total",
+			Help:      "Number of finished syncs, partitioned by repo and result.",
+		}, []string{"repo", "result"}),
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "sync_duration_seconds",
+			Help:      "Duration of finished syncs.",
+			// 1m ~ 34h
+			Buckets: prometheus.ExponentialBuckets(60, 2, 12),
+		}, []string{"repo"}),
+		dockerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "docker_errors_total",
+			Help:      "Number of failed Docker API calls, partitioned by operation.",
+		}, []string{"operation"}),
+		imageUpgrades: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_upgrades_total",
+			Help:      "Number of image upgrades, partitioned by result.",
+		}, []string{"result"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "code"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.syncRuns,
+		m.syncDuration,
+		m.dockerErrors,
+		m.imageUpgrades,
+		m.httpRequestDuration,
+		&repoMetaCollector{db: db, logger: logger},
+	)
+	return m
+}
+
+func syncResult(code int) string {
+	switch code {
+	case 0:
+		return "success"
+	case api.ExitCodeTimeout:
+		return "timeout"
+	case api.ExitCodeCancelled:
+		return "cancelled"
+	default:
+		return "failure"
+	}
+}
+
+func (m *metrics) observeSync(record model.SyncRecord) {
+	m.syncRuns.WithLabelValues(record.Name, syncResult(record.ExitCode)).Inc()
+	if record.StartedAt > 0 && record.FinishedAt >= record.StartedAt {
+		m.syncDuration.WithLabelValues(record.Name).Observe(float64(record.FinishedAt - record.StartedAt))
+	}
+}
+
+func (m *metrics) observeImageUpgrade(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.imageUpgrades.WithLabelValues(result).Inc()
+}
+
+func (m *metrics) observeRequest(c echo.Context, v middleware.RequestLoggerValues) {
+	route := c.Path()
+	if len(route) == 0 {
+		route = "unknown"
+	}
+	m.httpRequestDuration.
+		WithLabelValues(v.Method, route, strconv.Itoa(v.Status)).
+		Observe(v.Latency.Seconds())
+}
+
+var (
+	repoSyncingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "repo", "syncing"),
+		"Whether the repo is syncing.",
+		[]string{"repo"}, nil,
+	)
+	repoSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "repo", "size_bytes"),
+		"Size of the repo. -1 means unknown.",
+		[]string{"repo"}, nil,
+	)
+	repoLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "repo", "last_success_timestamp_seconds"),
+		"Unix timestamp of the last successful sync.",
+		[]string{"repo"}, nil,
+	)
+	repoExitCodeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "repo", "exit_code"),
+		"Exit code of the last sync.",
+		[]string{"repo"}, nil,
+	)
+	repoNextRunDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "repo", "next_run_timestamp_seconds"),
+		"Unix timestamp of the next scheduled sync.",
+		[]string{"repo"}, nil,
+	)
+)
+
+// repoMetaCollector exports the RepoMetas in the database as gauges on every scrape.
+type repoMetaCollector struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func (r *repoMetaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- repoSyncingDesc
+	ch <- repoSizeDesc
+	ch <- repoLastSuccessDesc
+	ch <- repoExitCodeDesc
+	ch <- repoNextRunDesc
+}
+
+func (r *repoMetaCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var metas []model.RepoMeta
+	err := r.db.WithContext(ctx).Find(&metas).Error
+	if err != nil {
+		r.logger.Error("Fail to list RepoMetas", slogErrAttr(err))
+		return
+	}
+	for _, meta := range metas {
+		syncing := 0.0
+		if meta.Syncing {
+			syncing = 1
+		}
+		ch <- prometheus.MustNewConstMetric(repoSyncingDesc, prometheus.GaugeValue, syncing, meta.Name)
+		ch <- prometheus.MustNewConstMetric(repoSizeDesc, prometheus.GaugeValue, float64(meta.Size), meta.Name)
+		ch <- prometheus.MustNewConstMetric(repoLastSuccessDesc, prometheus.GaugeValue, float64(meta.LastSuccess), meta.Name)
+		ch <- prometheus.MustNewConstMetric(repoExitCodeDesc, prometheus.GaugeValue, float64(meta.ExitCode), meta.Name)
+		ch <- prometheus.MustNewConstMetric(repoNextRunDesc, prometheus.GaugeValue, float64(meta.NextRun), meta.Name)
+	}
+}
+
+// instrumentedDockerClient counts the failed calls to the underlying docker.Client.
+type instrumentedDockerClient struct {
+	docker.Client
+	errors *prometheus.CounterVec
+}
+
+func (c *instrumentedDockerClient) observe(op string, err error) {
+	if err != nil {
+		c.errors.WithLabelValues(op).Inc()
+	}
+}
+
+func (c *instrumentedDockerClient) RunContainer(ctx context.Context, config docker.RunContainerConfig) (string, error) {
+	id, err := c.Client.RunContainer(ctx, config)
+	c.observe("run_container", err)
+	return id, err
+}
+
+func (c *instrumentedDockerClient) WaitContainerWithTimeout(id string, timeout time.Duration) (int, error) {
+	code, err := c.Client.WaitContainerWithTimeout(id, timeout)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		c.observe("wait_container", err)
+	}
+	return code, err
+}
+
+func (c *instrumentedDockerClient) StopContainerWithTimeout(id string, timeout time.Duration) error {
+	err := c.Client.StopContainerWithTimeout(id, timeout)
+	c.observe("stop_container", err)
+	return err
+}
+
+func (c *instrumentedDockerClient) RemoveContainerWithTimeout(id string, timeout time.Duration) error {
+	err := c.Client.RemoveContainerWithTimeout(id, timeout)
+	c.observe("remove_container", err)
+	return err
+}
+
+func (c *instrumentedDockerClient) ListContainersWithTimeout(running bool, timeout time.Duration) ([]docker.ContainerSummary, error) {
+	cts, err := c.Client.ListContainersWithTimeout(running, timeout)
+	c.observe("list_containers", err)
+	return cts, err
+}
+
+func (c *instrumentedDockerClient) PullImages(refs []string) error {
+	err := c.Client.PullImages(refs)
+	c.observe("pull_images", err)
+	return err
+}
+
+func (c *instrumentedDockerClient) RemoveDanglingImages(keep []string) error {
+	err := c.Client.RemoveDanglingImages(keep)
+	c.observe("remove_dangling_images", err)
+	return err
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/docker"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func TestMetrics(t *testing.T) {
+	te := NewTestEnv(t)
+	const name = "repo0"
+	require.NoError(t, te.server.db.Create(&model.RepoMeta{
+		Name:        name,
+		Size:        1024,
+		LastSuccess: 1700000000,
+		PrevRun:     time.Now().Unix(),
+	}).Error)
+
+	id, err := te.server.dockerCli.RunContainer(context.TODO(), docker.RunContainerConfig{
+		Name: name,
+	})
+	require.NoError(t, err)
+	te.server.waitForSync(name, id, "", "", 0)
+
+	resp, err := resty.New().R().Get(te.httpSrv.URL + "/metrics")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	body := string(resp.Body())
+	require.Contains(t, body, `yuki_repo_syncing{repo="repo0"} 0`)
+	require.Contains(t, body, `yuki_repo_exit_code{repo="repo0"} 0`)
+	require.Contains(t, body, `yuki_sync_runs_total{repo="repo0",result="success"} 1`)
+	require.Contains(t, body, `yuki_sync_duration_seconds_count{repo="repo0"} 1`)
+}
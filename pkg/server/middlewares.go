@@ -1,8 +1,14 @@
 package server
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -35,3 +41,59 @@ func setLogger(logger *slog.Logger) echo.MiddlewareFunc {
 func getLogger(c echo.Context) *slog.Logger {
 	return c.Get(ctxKeyLogger).(*slog.Logger)
 }
+
+// Scopes of the bearer tokens.
+const (
+	scopeReadMeta = "read-meta"
+	scopeSync     = "sync"
+	scopeReload   = "reload"
+	// scopeAdmin implies all the other scopes.
+	scopeAdmin = "admin"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireScope rejects requests that do not carry a token with the given scope.
+// Authentication is disabled if no token is configured.
+func (s *Server) requireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := s.checkScope(c, scope)
+			if err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// checkScope returns an HTTP error if the request does not carry a token with the given scope.
+// It is used directly by the public APIs that show more details to the authorized clients.
+func (s *Server) checkScope(c echo.Context, scope string) error {
+	tokens := s.config.Tokens
+	if len(tokens) == 0 {
+		return nil
+	}
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	bearer, found := strings.CutPrefix(auth, "Bearer ")
+	if !found || len(bearer) == 0 {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+		return newHTTPError(http.StatusUnauthorized, "Missing bearer token")
+	}
+	hashed := []byte(hashToken(bearer))
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare(hashed, []byte(strings.ToLower(token.Hash))) != 1 {
+			continue
+		}
+		if !slices.Contains(token.Scopes, scope) && !slices.Contains(token.Scopes, scopeAdmin) {
+			return newHTTPError(http.StatusForbidden, fmt.Sprintf("Token %q does not have scope %q", token.Name, scope))
+		}
+		c.Set(ctxKeyLogger, getLogger(c).With(slog.String("token", token.Name)))
+		return nil
+	}
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	return newHTTPError(http.StatusUnauthorized, "Invalid bearer token")
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func TestRequireScope(t *testing.T) {
+	te := NewTestEnv(t)
+	require.NoError(t, te.server.db.Create(&model.Repo{Name: "repo0"}).Error)
+	te.server.config.Tokens = []TokenConfig{
+		{
+			Name:   "reader",
+			Hash:   hashToken("reader-token"),
+			Scopes: []string{scopeReadMeta},
+		},
+		{
+			Name:   "admin",
+			Hash:   hashToken("admin-token"),
+			Scopes: []string{scopeAdmin},
+		},
+	}
+
+	testCases := map[string]struct {
+		token        string
+		method       string
+		path         string
+		expectStatus int
+	}{
+		"public API": {
+			method:       http.MethodGet,
+			path:         "/metas",
+			expectStatus: http.StatusOK,
+		},
+		"missing token": {
+			method:       http.MethodGet,
+			path:         "/repos",
+			expectStatus: http.StatusUnauthorized,
+		},
+		"invalid token": {
+			token:        "foo",
+			method:       http.MethodGet,
+			path:         "/repos",
+			expectStatus: http.StatusUnauthorized,
+		},
+		"reader can read": {
+			token:        "reader-token",
+			method:       http.MethodGet,
+			path:         "/repos/repo0",
+			expectStatus: http.StatusOK,
+		},
+		"reader cannot delete": {
+			token:        "reader-token",
+			method:       http.MethodDelete,
+			path:         "/repos/repo0",
+			expectStatus: http.StatusForbidden,
+		},
+		"admin can do anything": {
+			token:        "admin-token",
+			method:       http.MethodGet,
+			path:         "/repos",
+			expectStatus: http.StatusOK,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := te.RESTClient().R()
+			if len(tc.token) > 0 {
+				req.SetAuthToken(tc.token)
+			}
+			resp, err := req.Execute(tc.method, tc.path)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectStatus, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const mirrorzVersion = 1.7
+
+// mirrorzStatus converts the metadata of the repo into the status string of mirrorz.
+func mirrorzStatus(meta model.RepoMeta) string {
+	var (
+		b         strings.Builder
+		succeeded bool
+	)
+	flag := func(f byte, ts int64) {
+		b.WriteByte(f)
+		if ts > 0 {
+			fmt.Fprintf(&b, "%d", ts)
+		}
+	}
+	switch {
+	case meta.Paused:
+		flag('P', 0)
+	case meta.Syncing:
+		flag('Y', meta.PrevRun)
+	case meta.ExitCode != 0:
+		flag('F', meta.PrevRun)
+	case meta.LastSuccess > 0:
+		flag('S', meta.LastSuccess)
+		succeeded = true
+	case meta.PrevRun == 0:
+		flag('N', 0)
+	default:
+		flag('U', 0)
+	}
+	// O is the last success when the current status is not success.
+	if !succeeded && meta.LastSuccess > 0 {
+		flag('O', meta.LastSuccess)
+	}
+	if !meta.Paused && meta.NextRun > 0 {
+		flag('X', meta.NextRun)
+	}
+	return b.String()
+}
+
+func (s *Server) handlerGetMirrorz(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	site := s.config.Mirrorz
+	if len(site.URL) == 0 {
+		return newHTTPError(http.StatusNotFound, "mirrorz is not configured")
+	}
+
+	db := s.getDB(c)
+	var metas []model.RepoMeta
+	err := db.Order("name").Find(&metas).Error
+	if err != nil {
+		const msg = "Fail to list RepoMetas"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	var repos []model.Repo
+	err = db.Select("name", "description", "help_url").Find(&repos).Error
+	if err != nil {
+		const msg = "Fail to list Repos"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	repoByName := make(map[string]model.Repo, len(repos))
+	for _, repo := range repos {
+		repoByName[repo.Name] = repo
+	}
+
+	resp := api.MirrorzResponse{
+		Version: mirrorzVersion,
+		Site: api.MirrorzSite{
+			URL:          site.URL,
+			Logo:         site.Logo,
+			LogoDarkmode: site.LogoDarkmode,
+			Abbr:         site.Abbr,
+			Name:         site.Name,
+			Homepage:     site.Homepage,
+			Issue:        site.Issue,
+			Request:      site.Request,
+			Email:        site.Email,
+			Group:        site.Group,
+			Disk:         site.Disk,
+			Note:         site.Note,
+			Big:          site.Big,
+			Banner:       site.Banner,
+		},
+		Info:    []any{},
+		Mirrors: make([]api.MirrorzMirror, 0, len(metas)),
+	}
+	for _, meta := range metas {
+		repo := repoByName[meta.Name]
+		m := api.MirrorzMirror{
+			Cname:    meta.Name,
+			Desc:     repo.Description,
+			URL:      "/" + meta.Name,
+			Status:   mirrorzStatus(meta),
+			Help:     repo.HelpURL,
+			Upstream: meta.Upstream,
+		}
+		if meta.Size > 0 {
+			m.Size = units.BytesSize(float64(meta.Size))
+		}
+		resp.Mirrors = append(resp.Mirrors, m)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
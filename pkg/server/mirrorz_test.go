@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func TestMirrorzStatus(t *testing.T) {
+	testCases := map[string]struct {
+		meta   model.RepoMeta
+		expect string
+	}{
+		"new": {
+			meta:   model.RepoMeta{NextRun: 300},
+			expect: "NX300",
+		},
+		"success": {
+			meta:   model.RepoMeta{PrevRun: 100, LastSuccess: 200, NextRun: 300},
+			expect: "S200X300",
+		},
+		"syncing": {
+			meta:   model.RepoMeta{Syncing: true, PrevRun: 250, LastSuccess: 200, NextRun: 300},
+			expect: "Y250O200X300",
+		},
+		"failed": {
+			meta:   model.RepoMeta{ExitCode: 1, PrevRun: 250, LastSuccess: 200, NextRun: 300},
+			expect: "F250O200X300",
+		},
+		"never succeeded": {
+			meta:   model.RepoMeta{ExitCode: 1, PrevRun: 250},
+			expect: "F250",
+		},
+		"paused": {
+			meta:   model.RepoMeta{Paused: true, PrevRun: 100, LastSuccess: 200, NextRun: 300},
+			expect: "PO200",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expect, mirrorzStatus(tc.meta))
+		})
+	}
+}
+
+func TestHandlerGetMirrorz(t *testing.T) {
+	te := NewTestEnv(t)
+	cli := te.RESTClient()
+
+	resp, err := cli.R().Get("/mirrorz")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	te.server.config.Mirrorz = MirrorzConfig{
+		URL:  "https://mirrors.example.com",
+		Abbr: "EXAMPLE",
+		Name: "Example Mirror",
+	}
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "ubuntu", Description: "Ubuntu", HelpURL: "/help/ubuntu"},
+		{Name: "debian"},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{
+		{Name: "ubuntu", Upstream: "rsync://archive.ubuntu.com/ubuntu/", Size: 1024, LastSuccess: 200, PrevRun: 100},
+		{Name: "debian", ExitCode: 1, PrevRun: 100},
+	}).Error)
+
+	var result api.MirrorzResponse
+	resp, err = cli.R().SetResult(&result).Get("/mirrorz")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Equal(t, api.MirrorzResponse{
+		Version: mirrorzVersion,
+		Site: api.MirrorzSite{
+			URL:  "https://mirrors.example.com",
+			Abbr: "EXAMPLE",
+			Name: "Example Mirror",
+		},
+		Info: []any{},
+		Mirrors: []api.MirrorzMirror{
+			{
+				Cname:  "debian",
+				URL:    "/debian",
+				Status: "F100",
+			},
+			{
+				Cname:    "ubuntu",
+				Desc:     "Ubuntu",
+				URL:      "/ubuntu",
+				Status:   "S200",
+				Help:     "/help/ubuntu",
+				Upstream: "rsync://archive.ubuntu.com/ubuntu/",
+				Size:     "1KiB",
+			},
+		},
+	}, result)
+}
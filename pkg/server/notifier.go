@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const (
+	defaultNotifierTimeout       = 10 * time.Second
+	defaultNotifierMaxAttempts   = 3
+	defaultNotifierRetryInterval = 5 * time.Second
+	// notificationDeliveriesLimit is the number of the latest deliveries kept for each notifier.
+	notificationDeliveriesLimit = 1000
+)
+
+var notifierFuncs = template.FuncMap{
+	// json encodes the value so that it can be embedded in a JSON template safely.
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+type notifier struct {
+	NotifierConfig
+	body   *template.Template
+	client *http.Client
+}
+
+func newNotifiers(cfgs []NotifierConfig) ([]*notifier, error) {
+	notifiers := make([]*notifier, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		n := &notifier{NotifierConfig: cfg}
+		if len(n.Method) == 0 {
+			n.Method = http.MethodPost
+		}
+		if n.Timeout == 0 {
+			n.Timeout = defaultNotifierTimeout
+		}
+		if n.MaxAttempts == 0 {
+			n.MaxAttempts = defaultNotifierMaxAttempts
+		}
+		if n.RetryInterval == 0 {
+			n.RetryInterval = defaultNotifierRetryInterval
+		}
+		if len(cfg.Body) > 0 {
+			tmpl, err := template.New(cfg.Name).Funcs(notifierFuncs).Parse(cfg.Body)
+			if err != nil {
+				return nil, fmt.Errorf("parse body of notifier %q: %w", cfg.Name, err)
+			}
+			n.body = tmpl
+		}
+		n.client = &http.Client{Timeout: n.Timeout}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// match reports whether the payload triggers the notifier.
+func (n *notifier) match(p api.WebhookPayload) bool {
+	if len(n.Repos) > 0 && !slices.Contains(n.Repos, p.Repo) {
+		return false
+	}
+	if !slices.Contains(n.On, p.Event) {
+		return false
+	}
+	switch p.Event {
+	case api.NotifyOnFailure, api.NotifyOnTimeout:
+		// Only the failure reaching the threshold is reported, instead of every failure after it.
+		// A zero threshold reports every failure.
+		return n.FailureThreshold == 0 || p.ConsecutiveFailures == n.FailureThreshold
+	case api.NotifyOnRecovery:
+		// Recovery is only sent for the outages that have been reported.
+		return p.ConsecutiveFailures >= n.FailureThreshold
+	}
+	return true
+}
+
+func (n *notifier) render(p api.WebhookPayload) ([]byte, error) {
+	if n.body == nil {
+		return json.Marshal(p)
+	}
+	var buf bytes.Buffer
+	err := n.body.Execute(&buf, p)
+	return buf.Bytes(), err
+}
+
+// redactURLError removes the URL from the error, since the URLs of webhooks often contain secrets.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s webhook: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// send sends a single request. The status code is zero if no response is received.
+func (n *notifier) send(ctx context.Context, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, n.Method, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "yukid")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver sends the notification and retries on failure. The result is saved as a NotificationDelivery.
+func (s *Server) deliver(n *notifier, p api.WebhookPayload) {
+	l := s.logger.With(
+		slog.String("repo", p.Repo),
+		slog.String("notifier", n.Name),
+		slog.String("event", p.Event),
+	)
+	delivery := model.NotificationDelivery{
+		Notifier: n.Name,
+		Repo:     p.Repo,
+		Event:    p.Event,
+	}
+	body, err := n.render(p)
+	if err != nil {
+		l.Error("Fail to render webhook body", slogErrAttr(err))
+		delivery.Error = err.Error()
+	} else {
+		delay := n.RetryInterval
+		for delivery.Attempts < n.MaxAttempts {
+			if delivery.Attempts > 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			delivery.Attempts++
+			delivery.StatusCode, err = n.send(context.Background(), body)
+			if err == nil {
+				delivery.Delivered = true
+				delivery.Error = ""
+				break
+			}
+			delivery.Error = err.Error()
+			l.Warn("Fail to send webhook", slog.Int("attempt", delivery.Attempts), slogErrAttr(err))
+		}
+	}
+	if delivery.Delivered {
+		l.Info("Webhook delivered", slog.Int("attempts", delivery.Attempts))
+	} else {
+		l.Error("Fail to deliver webhook", slog.Int("attempts", delivery.Attempts))
+	}
+	err = s.db.Create(&delivery).Error
+	if err != nil {
+		l.Error("Fail to save NotificationDelivery", slogErrAttr(err))
+	}
+	err = s.pruneNotificationDeliveries(n.Name, notificationDeliveriesLimit)
+	if err != nil {
+		l.Error("Fail to prune NotificationDeliveries", slogErrAttr(err))
+	}
+}
+
+// pruneNotificationDeliveries removes the deliveries of the notifier except the latest ones.
+func (s *Server) pruneNotificationDeliveries(name string, keep int) error {
+	latest := s.db.
+		Model(&model.NotificationDelivery{}).
+		Select("id").
+		Where("notifier = ?", name).
+		Order("id DESC").
+		Limit(keep)
+	return s.db.
+		Where("notifier = ? AND id NOT IN (?)", name, latest).
+		Delete(&model.NotificationDelivery{}).Error
+}
+
+// countFailuresBefore returns the number of consecutive failed syncs of the repo before the given record.
+// Cancelled and unfinished syncs are ignored.
+func (s *Server) countFailuresBefore(name string, id uint) (int, error) {
+	var lastSuccess model.SyncRecord
+	err := s.db.
+		Where("name = ? AND exit_code = 0 AND finished_at > 0 AND id < ?", name, id).
+		Order("id DESC").
+		Limit(1).
+		Find(&lastSuccess).Error
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = s.db.
+		Model(&model.SyncRecord{}).
+		Where("name = ? AND id > ? AND id < ? AND exit_code != 0 AND finished_at > 0 AND cancelled = ?", name, lastSuccess.ID, id, false).
+		Count(&count).Error
+	return int(count), err
+}
+
+// notify fires the webhooks that match the result of the sync.
+func (s *Server) notify(record model.SyncRecord) {
+	if len(s.notifiers) == 0 || record.Cancelled || record.ID == 0 {
+		return
+	}
+	l := s.logger.With(slog.String("repo", record.Name))
+	failures, err := s.countFailuresBefore(record.Name, record.ID)
+	if err != nil {
+		l.Error("Fail to count failed syncs", slogErrAttr(err))
+		return
+	}
+	p := api.WebhookPayload{
+		Repo:                record.Name,
+		ExitCode:            record.ExitCode,
+		ConsecutiveFailures: failures,
+		StartedAt:           record.StartedAt,
+		FinishedAt:          record.FinishedAt,
+		Size:                record.SizeAfter,
+		Upstream:            record.Upstream,
+	}
+	if record.ExitCode == 0 {
+		if failures == 0 {
+			return
+		}
+		p.Event = api.NotifyOnRecovery
+	} else {
+		p.ConsecutiveFailures++
+		p.Event = api.NotifyOnFailure
+	}
+	for _, n := range s.notifiers {
+		np := p
+		// Timeout syncs are reported as timeout events if the notifier asks for them.
+		if record.TimedOut && slices.Contains(n.On, api.NotifyOnTimeout) {
+			np.Event = api.NotifyOnTimeout
+		}
+		if !n.match(np) {
+			continue
+		}
+		go s.deliver(n, np)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func (s *Server) handlerListNotificationDeliveries(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	var req api.ListNotificationDeliveriesRequest
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid query: %v", err))
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = defaultPageSize
+	}
+	if req.PageSize > maxPageSize {
+		req.PageSize = maxPageSize
+	}
+
+	var (
+		total      int64
+		deliveries []model.NotificationDelivery
+	)
+	cond := model.NotificationDelivery{
+		Notifier: req.Notifier,
+		Repo:     req.Repo,
+	}
+	db := s.getDB(c)
+	err = db.Model(&model.NotificationDelivery{}).Where(cond).Count(&total).Error
+	if err != nil {
+		const msg = "Fail to count NotificationDeliveries"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	err = db.
+		Where(cond).
+		Order("id DESC").
+		Offset((req.Page - 1) * req.PageSize).
+		Limit(req.PageSize).
+		Find(&deliveries).Error
+	if err != nil {
+		const msg = "Fail to list NotificationDeliveries"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+
+	resp := api.ListNotificationDeliveriesResponse{
+		Total:      total,
+		Deliveries: make([]api.NotificationDelivery, len(deliveries)),
+	}
+	for i, d := range deliveries {
+		resp.Deliveries[i] = api.NotificationDelivery{
+			ID:         d.ID,
+			Notifier:   d.Notifier,
+			Repo:       d.Repo,
+			Event:      d.Event,
+			Attempts:   d.Attempts,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Delivered:  d.Delivered,
+			CreatedAt:  d.CreatedAt,
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
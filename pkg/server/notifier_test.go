@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+	testutils "github.com/ustclug/Yuki/test/utils"
+)
+
+type webhookRecorder struct {
+	mu       sync.Mutex
+	failures int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	r.bodies = append(r.bodies, string(body))
+	r.headers = append(r.headers, req.Header.Clone())
+}
+
+func (r *webhookRecorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+func TestNotify(t *testing.T) {
+	te := NewTestEnv(t)
+	rec := &webhookRecorder{failures: 1}
+	hook := httptest.NewServer(rec)
+	t.Cleanup(hook.Close)
+
+	notifiers, err := newNotifiers([]NotifierConfig{
+		{
+			Name:             "chat",
+			URL:              hook.URL,
+			Headers:          map[string]string{"x-token": "secret"},
+			Body:             `{"text": {{ printf "%s %s" .Repo .Event | json }}, "failures": {{ .ConsecutiveFailures }}}`,
+			On:               []string{api.NotifyOnFailure, api.NotifyOnRecovery},
+			FailureThreshold: 2,
+			RetryInterval:    10 * time.Millisecond,
+		},
+		{
+			Name:  "other",
+			URL:   hook.URL,
+			On:    []string{api.NotifyOnFailure},
+			Repos: []string{"other"},
+		},
+	})
+	require.NoError(t, err)
+	te.server.notifiers = notifiers
+
+	name := te.RandomString()
+	finish := func(code int) {
+		record := model.SyncRecord{Name: name, ExitCode: code, FinishedAt: time.Now().Unix()}
+		require.NoError(t, te.server.db.Create(&record).Error)
+		te.server.notify(record)
+	}
+	listDeliveries := func() api.ListNotificationDeliveriesResponse {
+		var resp api.ListNotificationDeliveriesResponse
+		r, err := te.RESTClient().R().
+			SetQueryParam("notifier", "chat").
+			SetResult(&resp).
+			Get("/notifications")
+		require.NoError(t, err)
+		require.True(t, r.IsSuccess(), "Unexpected response: %s", r.Body())
+		return resp
+	}
+
+	// Below the threshold.
+	finish(1)
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, rec.received())
+
+	finish(2)
+	testutils.PollUntilTimeout(t, 5*time.Second, func() bool {
+		return listDeliveries().Total == 1
+	})
+	d := listDeliveries().Deliveries[0]
+	require.True(t, d.Delivered)
+	require.Equal(t, api.NotifyOnFailure, d.Event)
+	require.Equal(t, 2, d.Attempts, "the first attempt should fail")
+	require.Equal(t, http.StatusOK, d.StatusCode)
+
+	bodies := rec.received()
+	require.Len(t, bodies, 1)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal([]byte(bodies[0]), &body))
+	require.Equal(t, name+" failure", body["text"])
+	require.EqualValues(t, 2, body["failures"])
+	require.Equal(t, "secret", rec.headers[0].Get("X-Token"))
+
+	finish(0)
+	testutils.PollUntilTimeout(t, 5*time.Second, func() bool {
+		return listDeliveries().Total == 2
+	})
+	require.Equal(t, api.NotifyOnRecovery, listDeliveries().Deliveries[0].Event)
+
+	// No recovery without previous failures.
+	finish(0)
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, rec.received(), 2)
+
+	// No recovery for the failures below the threshold.
+	finish(1)
+	finish(0)
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, rec.received(), 2)
+
+	// Unfinished syncs are neither successes nor failures.
+	finish(1)
+	require.NoError(t, te.server.db.Create(&model.SyncRecord{Name: name}).Error)
+	finish(3)
+	testutils.PollUntilTimeout(t, 5*time.Second, func() bool {
+		return listDeliveries().Total == 3
+	})
+	require.Equal(t, api.NotifyOnFailure, listDeliveries().Deliveries[0].Event)
+
+	// The failures after the threshold are not reported again.
+	finish(4)
+	time.Sleep(100 * time.Millisecond)
+	require.EqualValues(t, 3, listDeliveries().Total)
+}
+
+func TestPruneNotificationDeliveries(t *testing.T) {
+	te := NewTestEnv(t)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, te.server.db.Create([]model.NotificationDelivery{
+			{Notifier: "chat", Repo: fmt.Sprintf("repo%d", i)},
+			{Notifier: "other", Repo: fmt.Sprintf("repo%d", i)},
+		}).Error)
+	}
+	require.NoError(t, te.server.pruneNotificationDeliveries("chat", 2))
+
+	var deliveries []model.NotificationDelivery
+	require.NoError(t, te.server.db.Where("notifier = ?", "chat").Order("id").Find(&deliveries).Error)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, "repo3", deliveries[0].Repo)
+	require.Equal(t, "repo4", deliveries[1].Repo)
+	var others int64
+	require.NoError(t, te.server.db.Model(&model.NotificationDelivery{}).Where("notifier = ?", "other").Count(&others).Error)
+	require.EqualValues(t, 5, others)
+}
+
+func TestNotifierRedactURL(t *testing.T) {
+	n, err := newNotifiers([]NotifierConfig{{Name: "a", URL: "http://127.0.0.1:1/hooks/secret-token"}})
+	require.NoError(t, err)
+	_, err = n[0].send(context.Background(), nil)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret-token")
+}
+
+func TestNotifierMatch(t *testing.T) {
+	n := &notifier{NotifierConfig: NotifierConfig{
+		On:               []string{api.NotifyOnTimeout, api.NotifyOnRecovery},
+		FailureThreshold: 3,
+		Repos:            []string{"ubuntu"},
+	}}
+	require.False(t, n.match(api.WebhookPayload{Repo: "debian", Event: api.NotifyOnRecovery}))
+	require.False(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnRecovery, ConsecutiveFailures: 1}))
+	require.True(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnRecovery, ConsecutiveFailures: 3}))
+	require.False(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnFailure, ConsecutiveFailures: 3}))
+	require.False(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnTimeout, ConsecutiveFailures: 2}))
+	require.True(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnTimeout, ConsecutiveFailures: 3}))
+	// Only the failure reaching the threshold is reported.
+	require.False(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnTimeout, ConsecutiveFailures: 4}))
+	require.True(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnRecovery, ConsecutiveFailures: 4}))
+
+	// A zero threshold reports every failure.
+	n.FailureThreshold = 0
+	require.True(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnTimeout, ConsecutiveFailures: 1}))
+	require.True(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnTimeout, ConsecutiveFailures: 2}))
+
+	// The threshold does not apply to staleness.
+	n.On = []string{api.NotifyOnStale}
+	require.True(t, n.match(api.WebhookPayload{Repo: "ubuntu", Event: api.NotifyOnStale}))
+}
+
+func TestNewNotifiers(t *testing.T) {
+	notifiers, err := newNotifiers([]NotifierConfig{{Name: "a", URL: "http://127.0.0.1"}})
+	require.NoError(t, err)
+	require.Equal(t, http.MethodPost, notifiers[0].Method)
+	require.Equal(t, defaultNotifierMaxAttempts, notifiers[0].MaxAttempts)
+
+	_, err = newNotifiers([]NotifierConfig{{Name: "b", Body: "{{ .Repo "}})
+	require.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+type queueItem struct {
+	name     string
+	group    string
+	priority int
+	seq      uint64
+}
+
+// syncQueue limits the number of concurrent syncs, both globally and per concurrency group.
+// Repos that exceed the limits are kept in the queue, ordered by priority and then FIFO.
+type syncQueue struct {
+	mu sync.Mutex
+
+	// maxRunning is the global limit. Zero means unlimited.
+	maxRunning int
+	// groupLimits is the limit of each concurrency group. Groups not listed are unlimited.
+	groupLimits map[string]int
+
+	running      map[string]string
+	groupRunning map[string]int
+	pending      []queueItem
+	seq          uint64
+
+	// notify receives a value whenever a slot is released.
+	notify chan struct{}
+}
+
+func newSyncQueue(maxRunning int, groupLimits map[string]int) *syncQueue {
+	return &syncQueue{
+		maxRunning:   maxRunning,
+		groupLimits:  groupLimits,
+		running:      make(map[string]string),
+		groupRunning: make(map[string]int),
+		notify:       make(chan struct{}, 1),
+	}
+}
+
+// normalizeGroup returns the key of the concurrency group. The group names are case-insensitive
+// since viper lowercases all the keys of the config.
+func normalizeGroup(group string) string {
+	return strings.ToLower(group)
+}
+
+// push enqueues the given repo. It returns false if the repo is already queued or running.
+func (q *syncQueue) push(name, group string, priority int) bool {
+	group = normalizeGroup(group)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.running[name]; ok {
+		return false
+	}
+	for _, item := range q.pending {
+		if item.name == name {
+			return false
+		}
+	}
+	q.seq++
+	q.pending = append(q.pending, queueItem{
+		name:     name,
+		group:    group,
+		priority: priority,
+		seq:      q.seq,
+	})
+	sort.SliceStable(q.pending, func(i, j int) bool {
+		a, b := q.pending[i], q.pending[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.seq < b.seq
+	})
+	return true
+}
+
+// pop removes the repos that can be started without exceeding the limits from the queue,
+// and marks them as running.
+func (q *syncQueue) pop() []queueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ready []queueItem
+	remaining := q.pending[:0]
+	for _, item := range q.pending {
+		if _, ok := q.running[item.name]; ok {
+			// Someone has started the sync already.
+			continue
+		}
+		if !q.hasSlotLocked(item.group) {
+			remaining = append(remaining, item)
+			continue
+		}
+		q.acquireLocked(item.name, item.group)
+		ready = append(ready, item)
+	}
+	q.pending = remaining
+	return ready
+}
+
+func (q *syncQueue) hasSlotLocked(group string) bool {
+	if q.maxRunning > 0 && len(q.running) >= q.maxRunning {
+		return false
+	}
+	if len(group) == 0 {
+		return true
+	}
+	limit, ok := q.groupLimits[group]
+	return !ok || q.groupRunning[group] < limit
+}
+
+func (q *syncQueue) acquireLocked(name, group string) {
+	if _, ok := q.running[name]; ok {
+		return
+	}
+	q.running[name] = group
+	if len(group) > 0 {
+		q.groupRunning[group]++
+	}
+}
+
+// remove removes the repo from the queue. It returns false if the repo is not queued.
+func (q *syncQueue) remove(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, item := range q.pending {
+		if item.name == name {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *syncQueue) isRunning(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.running[name]
+	return ok
+}
+
+// markRunning marks the repo as running regardless of the limits.
+// It is used for syncs that do not go through the queue, e.g. manual syncs.
+func (q *syncQueue) markRunning(name, group string) {
+	group = normalizeGroup(group)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.acquireLocked(name, group)
+}
+
+// release frees the slot occupied by the given repo.
+func (q *syncQueue) release(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	group, ok := q.running[name]
+	if !ok {
+		return
+	}
+	delete(q.running, name)
+	if len(group) > 0 {
+		q.groupRunning[group]--
+		if q.groupRunning[group] <= 0 {
+			delete(q.groupRunning, group)
+		}
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
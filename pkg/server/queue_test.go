@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func popNames(q *syncQueue) []string {
+	var names []string
+	for _, item := range q.pop() {
+		names = append(names, item.name)
+	}
+	return names
+}
+
+func TestSyncQueue(t *testing.T) {
+	q := newSyncQueue(3, map[string]int{"ustc": 1})
+
+	require.True(t, q.push("a", "ustc", 0))
+	require.True(t, q.push("b", "ustc", 0))
+	require.True(t, q.push("c", "", 0))
+	require.True(t, q.push("d", "", 10))
+	require.True(t, q.push("e", "", 0))
+	require.False(t, q.push("a", "ustc", 0), "duplicated push")
+
+	// d has the highest priority. b is blocked by the group limit.
+	require.Equal(t, []string{"d", "a", "c"}, popNames(q))
+	require.Empty(t, popNames(q), "global limit")
+	require.False(t, q.push("a", "ustc", 0), "push running repo")
+
+	q.release("a")
+	require.Len(t, q.notify, 1)
+	require.Equal(t, []string{"b"}, popNames(q))
+
+	q.release("c")
+	require.Equal(t, []string{"e"}, popNames(q))
+}
+
+func TestSyncQueueMarkRunning(t *testing.T) {
+	q := newSyncQueue(1, nil)
+	require.True(t, q.push("a", "", 0))
+	require.True(t, q.push("b", "", 0))
+
+	// a is started manually, so it should be dropped from the queue.
+	q.markRunning("a", "")
+	require.True(t, q.isRunning("a"))
+	require.Empty(t, popNames(q))
+
+	q.release("a")
+	require.False(t, q.isRunning("a"))
+	require.Equal(t, []string{"b"}, popNames(q))
+}
+
+func TestSyncQueueGroupCase(t *testing.T) {
+	q := newSyncQueue(0, map[string]int{"rsync-ustc": 1})
+	require.True(t, q.push("a", "Rsync-USTC", 0))
+	require.True(t, q.push("b", "rsync-ustc", 0))
+
+	// Both repos are in the same group.
+	require.Equal(t, []string{"a"}, popNames(q))
+	q.markRunning("c", "RSYNC-ustc")
+	q.release("a")
+	require.Empty(t, popNames(q))
+
+	q.release("c")
+	require.Equal(t, []string{"b"}, popNames(q))
+}
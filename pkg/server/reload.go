@@ -0,0 +1,385 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo/v4"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+	"github.com/ustclug/Yuki/pkg/repoconfig"
+	"github.com/ustclug/Yuki/pkg/set"
+)
+
+const defaultWatchDebounce = 2 * time.Second
+
+// Modes of strict_repo_config.
+const (
+	strictOff   = "off"
+	strictWarn  = "warn"
+	strictError = "error"
+)
+
+// errorMessage returns the message of HTTP errors or the error itself otherwise.
+func errorMessage(err error) string {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		if msg, ok := httpErr.Message.(string); ok {
+			return msg
+		}
+	}
+	return err.Error()
+}
+
+// checkConfigFields reports the unknown and mistyped fields found by check according to strict_repo_config.
+// The fields are returned as warnings, or fail the loading if strict_repo_config is "error".
+func (s *Server) checkConfigFields(l *slog.Logger, check func() ([]repoconfig.FieldIssue, error)) ([]api.ConfigError, error) {
+	if s.config.StrictRepoConfig == strictOff {
+		return nil, nil
+	}
+	issues, err := check()
+	if err != nil {
+		// Syntax errors are reported when loading the config.
+		return nil, nil
+	}
+	if len(issues) == 0 {
+		return nil, nil
+	}
+	warnings := make([]api.ConfigError, len(issues))
+	msgs := make([]string, len(issues))
+	for i, issue := range issues {
+		warnings[i] = api.ConfigError{
+			File:  issue.File,
+			Line:  issue.Line,
+			Error: issue.Message,
+		}
+		msgs[i] = issue.String()
+	}
+	if s.config.StrictRepoConfig == strictError {
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid config: %s", strings.Join(msgs, "; ")))
+	}
+	for _, issue := range issues {
+		l.Warn("Invalid field", slog.String("config", issue.File), slog.Int("line", issue.Line), slog.String("error", issue.Message))
+	}
+	return warnings, nil
+}
+
+type reloadOptions struct {
+	trigger string
+	// keepGoing skips the invalid configs and keeps their repos instead of failing the reload.
+	keepGoing bool
+	// dryRun computes the diff without touching the database or repoSchedules.
+	dryRun bool
+	// atomic applies all the changes in one transaction.
+	atomic bool
+}
+
+type loadedRepo struct {
+	repo     *model.Repo
+	schedule cron.Schedule
+}
+
+// diffRepo returns the fields that differ between the two repos, compared by their JSON representations.
+func diffRepo(before, after *model.Repo) ([]api.FieldChange, error) {
+	toMap := func(repo *model.Repo) (map[string]any, error) {
+		data, err := json.Marshal(repo)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]any
+		err = json.Unmarshal(data, &m)
+		return m, err
+	}
+	oldFields, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+	keys := set.New[string]()
+	for k := range oldFields {
+		keys.Add(k)
+	}
+	for k := range newFields {
+		keys.Add(k)
+	}
+	var changes []api.FieldChange
+	for _, k := range slices.Sorted(maps.Keys(keys)) {
+		if !reflect.DeepEqual(oldFields[k], newFields[k]) {
+			changes = append(changes, api.FieldChange{
+				Field: k,
+				Old:   oldFields[k],
+				New:   newFields[k],
+			})
+		}
+	}
+	return changes, nil
+}
+
+// reloadAllRepos loads all configs in repo_config_dir and deletes the repos whose config no longer exists.
+// All configs are validated before any change is made. If opts.keepGoing is false, the first invalid config
+// fails the reload. Otherwise, invalid configs are reported in the result and their repos are kept as is.
+func (s *Server) reloadAllRepos(db *gorm.DB, l *slog.Logger, opts reloadOptions) (api.ReloadResult, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	result := api.ReloadResult{
+		Time:    time.Now().Unix(),
+		Trigger: opts.trigger,
+		DryRun:  opts.dryRun,
+	}
+	if !opts.dryRun {
+		defer func() {
+			s.lastReload.Store(&result)
+		}()
+	}
+	fail := func(err error) (api.ReloadResult, error) {
+		result.Errors = append(result.Errors, api.ConfigError{Error: errorMessage(err)})
+		return result, err
+	}
+
+	l.Debug("Loading all configs")
+	loader, err := newConfigLoader(s.config.RepoConfigDir)
+	if err != nil {
+		return fail(err)
+	}
+	result.Warnings, err = s.checkConfigFields(l, loader.CheckDefaultsFields)
+	if err != nil {
+		return fail(err)
+	}
+	// Files are loaded by their names since the files with the same name in different dirs are merged.
+	files := set.New[string]()
+	for _, dir := range s.config.RepoConfigDir {
+		infos, err := os.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				l.Warn("Fail to list dir", slogErrAttr(err), slog.String("dir", dir))
+			}
+			continue
+		}
+		for _, info := range infos {
+			if !info.IsDir() && repoconfig.IsConfigFile(info.Name()) {
+				files.Add(info.Name())
+			}
+		}
+	}
+	loaded := make(map[string]loadedRepo)
+	// The invalid config files. Their repos are kept as is.
+	broken := set.New[string]()
+	for _, fileName := range slices.Sorted(maps.Keys(files)) {
+		warnings, err := s.checkConfigFields(l, func() ([]repoconfig.FieldIssue, error) {
+			return loader.CheckFields(fileName)
+		})
+		var (
+			repo     *model.Repo
+			schedule cron.Schedule
+		)
+		if err == nil {
+			result.Warnings = append(result.Warnings, warnings...)
+			repo, err = loadRepoConfig(loader, fileName)
+		}
+		if err == nil {
+			schedule, err = s.validateRepo(repo, fileName)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, api.ConfigError{File: fileName, Error: errorMessage(err)})
+			if !opts.keepGoing {
+				return result, err
+			}
+			l.Warn("Fail to load config", slog.String("config", fileName), slogErrAttr(err))
+			broken.Add(fileName)
+			continue
+		}
+		loaded[repo.Name] = loadedRepo{repo: repo, schedule: schedule}
+	}
+	result.Loaded = len(loaded)
+
+	var existing []model.Repo
+	err = db.Find(&existing).Error
+	if err != nil {
+		const msg = "Fail to list Repos"
+		l.Error(msg, slogErrAttr(err))
+		return fail(newHTTPError(http.StatusInternalServerError, msg))
+	}
+	existingNames := set.New[string]()
+	for i := range existing {
+		before := &existing[i]
+		existingNames.Add(before.Name)
+		cur, ok := loaded[before.Name]
+		if !ok {
+			configFile := before.ConfigFile
+			if len(configFile) == 0 {
+				// The repos saved by the older versions do not record their config files.
+				configFile = before.Name + suffixYAML
+			}
+			if _, ok := broken[configFile]; !ok && !before.APIManaged {
+				result.Removed = append(result.Removed, before.Name)
+			}
+			continue
+		}
+		changes, err := diffRepo(before, cur.repo)
+		if err != nil {
+			return fail(err)
+		}
+		if len(changes) > 0 {
+			result.Changed = append(result.Changed, api.RepoDiff{
+				Name:   before.Name,
+				Fields: changes,
+			})
+		}
+	}
+	for name := range loaded {
+		if _, ok := existingNames[name]; !ok {
+			result.Added = append(result.Added, name)
+		}
+	}
+	slices.Sort(result.Added)
+	slices.Sort(result.Removed)
+	slices.SortFunc(result.Changed, func(a, b api.RepoDiff) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	if opts.dryRun {
+		return result, nil
+	}
+
+	var saved []string
+	apply := func(tx *gorm.DB) error {
+		for name, cur := range loaded {
+			err := s.saveRepo(tx, l.With(slog.String("repo", name)), cur.repo, cur.schedule)
+			if err != nil {
+				return err
+			}
+			saved = append(saved, name)
+		}
+		l.Debug("Deleting unnecessary repos", slog.Any("repos", result.Removed))
+		err := tx.Where("name IN ?", result.Removed).Delete(&model.Repo{}).Error
+		if err != nil {
+			const msg = "Fail to delete Repos"
+			l.Error(msg, slogErrAttr(err))
+			return newHTTPError(http.StatusInternalServerError, msg)
+		}
+		err = tx.Where("name IN ?", result.Removed).Delete(&model.RepoMeta{}).Error
+		if err != nil {
+			const msg = "Fail to delete RepoMetas"
+			l.Error(msg, slogErrAttr(err))
+			if opts.atomic {
+				return newHTTPError(http.StatusInternalServerError, msg)
+			}
+		}
+		return nil
+	}
+	if opts.atomic {
+		err = db.Transaction(apply)
+		if err != nil {
+			// Nothing is changed.
+			saved = nil
+		}
+	} else {
+		err = apply(db)
+	}
+	// Keep repoSchedules consistent with the database even if the reload fails half-way.
+	for _, name := range saved {
+		s.repoSchedules.Set(name, loaded[name].schedule)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, name := range result.Removed {
+		s.repoSchedules.Remove(name)
+		s.events.publish(api.Event{
+			Type: api.EventRepoRemoved,
+			Repo: name,
+		})
+	}
+	for _, name := range result.Added {
+		s.events.publish(api.Event{
+			Type: api.EventRepoAdded,
+			Repo: name,
+		})
+	}
+	return result, nil
+}
+
+// watchRepoConfigs reloads all repos when the configs in repo_config_dir change.
+// Changes are debounced so that editing several files only triggers one reload.
+func (s *Server) watchRepoConfigs(ctx context.Context) error {
+	l := s.logger.With(slog.String("component", "watcher"))
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range s.config.RepoConfigDir {
+		err := watcher.Add(dir)
+		if err != nil {
+			l.Warn("Fail to watch dir", slogErrAttr(err), slog.String("dir", dir))
+		}
+	}
+
+	debounce := s.config.WatchDebounce
+	if debounce == 0 {
+		debounce = defaultWatchDebounce
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(ev.Name)
+				if name[0] == '.' || !strings.HasSuffix(name, suffixYAML) || ev.Has(fsnotify.Chmod) {
+					continue
+				}
+				l.Debug("Config changed", slog.String("config", ev.Name), slog.String("op", ev.Op.String()))
+				timer.Reset(debounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				l.Warn("Watcher error", slogErrAttr(err))
+			case <-timer.C:
+				result, err := s.reloadAllRepos(s.db.WithContext(ctx), l, reloadOptions{
+					trigger:   api.ReloadTriggerWatch,
+					keepGoing: true,
+					atomic:    true,
+				})
+				if err != nil {
+					l.Error("Fail to reload repos", slogErrAttr(err))
+					continue
+				}
+				l.Info("Repos reloaded",
+					slog.Int("loaded", result.Loaded),
+					slog.Any("added", result.Added),
+					slog.Any("removed", result.Removed),
+					slog.Int("errors", len(result.Errors)),
+					slog.Int("warnings", len(result.Warnings)),
+				)
+			}
+		}
+	}()
+	return nil
+}
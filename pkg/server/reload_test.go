@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+	testutils "github.com/ustclug/Yuki/test/utils"
+)
+
+const testRepoConfig = `
+cron: "* * * * *"
+image: "alpine:latest"
+storageDir: /tmp
+`
+
+func countRepos(t *testing.T, te *TestEnv, name string) int64 {
+	var count int64
+	require.NoError(t, te.server.db.Model(&model.Repo{}).Where(model.Repo{Name: name}).Count(&count).Error)
+	return count
+}
+
+func TestReloadAllReposKeepGoing(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+	}
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "broken"},
+		{Name: "vanished"},
+	}).Error)
+	testutils.WriteFile(t, filepath.Join(rootDir, "good.yaml"), "name: good"+testRepoConfig)
+	testutils.WriteFile(t, filepath.Join(rootDir, "broken.yaml"), "name: broken\ncron: invalid")
+
+	resp, err := te.RESTClient().R().Get("/reload")
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode())
+
+	// The API stops at the first invalid config.
+	resp, err = te.RESTClient().R().Post("/repos")
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode())
+	require.EqualValues(t, 1, countRepos(t, te, "vanished"))
+
+	result, err := te.server.reloadAllRepos(te.server.db, te.server.logger, reloadOptions{
+		trigger:   api.ReloadTriggerWatch,
+		keepGoing: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Loaded)
+	require.Equal(t, []string{"vanished"}, result.Removed)
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "broken.yaml", result.Errors[0].File)
+	require.Contains(t, result.Errors[0].Error, "Invalid")
+	require.EqualValues(t, 1, countRepos(t, te, "broken"))
+	require.EqualValues(t, 1, countRepos(t, te, "good"))
+	require.Zero(t, countRepos(t, te, "vanished"))
+
+	var status api.ReloadResult
+	resp, err = te.RESTClient().R().SetResult(&status).Get("/reload")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Equal(t, api.ReloadTriggerWatch, status.Trigger)
+	require.Equal(t, result.Errors, status.Errors)
+}
+
+func TestReloadAllReposMultipleDirs(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	dir1 := filepath.Join(rootDir, "cfg1")
+	dir2 := filepath.Join(rootDir, "cfg2")
+	require.NoError(t, os.Mkdir(dir1, 0o755))
+	require.NoError(t, os.Mkdir(dir2, 0o755))
+	te.server.config = Config{
+		RepoLogsDir:      filepath.Join(rootDir, "logs"),
+		RepoConfigDir:    []string{dir1, dir2},
+		StrictRepoConfig: strictWarn,
+	}
+	// The name of the repo differs from the name of its config file.
+	testutils.WriteFile(t, filepath.Join(dir1, "mirror.yaml"), "name: debian"+testRepoConfig)
+	testutils.WriteFile(t, filepath.Join(dir2, "mirror.yaml"), "imgae: alpine\n")
+	reload := func() api.ReloadResult {
+		result, err := te.server.reloadAllRepos(te.server.db, te.server.logger, reloadOptions{keepGoing: true})
+		require.NoError(t, err)
+		return result
+	}
+
+	// The file in both dirs is loaded once.
+	result := reload()
+	require.Equal(t, 1, result.Loaded)
+	require.Len(t, result.Warnings, 1)
+	require.Equal(t, []string{"debian"}, result.Added)
+
+	// The repo of the invalid config file is kept.
+	testutils.WriteFile(t, filepath.Join(dir2, "mirror.yaml"), "cron: invalid\n")
+	result = reload()
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "mirror.yaml", result.Errors[0].File)
+	require.Empty(t, result.Removed)
+	require.EqualValues(t, 1, countRepos(t, te, "debian"))
+}
+
+func TestReloadAllReposNextRun(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+	}
+	cfgFile := filepath.Join(rootDir, "repo0.yaml")
+	testutils.WriteFile(t, cfgFile, "name: repo0"+testRepoConfig)
+	reload := func() model.RepoMeta {
+		_, err := te.server.reloadAllRepos(te.server.db, te.server.logger, reloadOptions{})
+		require.NoError(t, err)
+		meta := model.RepoMeta{Name: "repo0"}
+		require.NoError(t, te.server.db.Take(&meta).Error)
+		return meta
+	}
+	require.NotZero(t, reload().NextRun)
+
+	// The schedule is kept if the cron is unchanged.
+	nextRun := time.Now().Add(30 * time.Minute).Unix()
+	require.NoError(t, te.server.db.
+		Where(model.RepoMeta{Name: "repo0"}).
+		Updates(&model.RepoMeta{NextRun: nextRun}).Error)
+	testutils.WriteFile(t, cfgFile, `
+name: repo0
+cron: "* * * * *"
+image: ubuntu
+storageDir: /tmp
+`)
+	require.Equal(t, nextRun, reload().NextRun)
+
+	testutils.WriteFile(t, cfgFile, `
+name: repo0
+cron: "0 0 1 1 *"
+image: ubuntu
+storageDir: /tmp
+`)
+	require.Greater(t, reload().NextRun, nextRun)
+}
+
+func TestHandlerReloadAllReposDryRun(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+	}
+	cli := te.RESTClient()
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo0.yaml"), "name: repo0"+testRepoConfig)
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo1.yaml"), "name: repo1"+testRepoConfig)
+	resp, err := cli.R().Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo0.yaml"), "name: repo0\nuser: mirror"+testRepoConfig)
+	require.NoError(t, os.Remove(filepath.Join(rootDir, "repo1.yaml")))
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo2.yaml"), "name: repo2"+testRepoConfig)
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo3.yaml"), "name: repo3\ncron: invalid")
+
+	var result api.ReloadResult
+	resp, err = cli.R().SetQueryParam("dryRun", "true").SetResult(&result).Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.True(t, result.DryRun)
+	require.Equal(t, []string{"repo2"}, result.Added)
+	require.Equal(t, []string{"repo1"}, result.Removed)
+	require.Equal(t, []api.RepoDiff{
+		{
+			Name:   "repo0",
+			Fields: []api.FieldChange{{Field: "user", Old: "", New: "mirror"}},
+		},
+	}, result.Changed)
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "repo3.yaml", result.Errors[0].File)
+
+	// Nothing is changed.
+	require.EqualValues(t, 1, countRepos(t, te, "repo1"))
+	require.Zero(t, countRepos(t, te, "repo2"))
+	require.False(t, te.server.repoSchedules.Has("repo2"))
+	require.Equal(t, api.ReloadTriggerAPI, te.server.lastReload.Load().Trigger)
+	require.False(t, te.server.lastReload.Load().DryRun)
+
+	// The invalid config fails the reload before any change is made.
+	resp, err = cli.R().SetQueryParam("atomic", "true").Post("/repos")
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode())
+	require.EqualValues(t, 1, countRepos(t, te, "repo1"))
+	require.Zero(t, countRepos(t, te, "repo2"))
+
+	require.NoError(t, os.Remove(filepath.Join(rootDir, "repo3.yaml")))
+	resp, err = cli.R().SetQueryParam("atomic", "true").Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Zero(t, countRepos(t, te, "repo1"))
+	require.EqualValues(t, 1, countRepos(t, te, "repo2"))
+	require.True(t, te.server.repoSchedules.Has("repo2"))
+	require.False(t, te.server.repoSchedules.Has("repo1"))
+
+	var repo model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "repo0"}).First(&repo).Error)
+	require.Equal(t, "mirror", repo.User)
+}
+
+func TestReloadAllReposAtomic(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+	}
+	// The log dir of repo1 cannot be created.
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, "logs"), 0o755))
+	testutils.WriteFile(t, filepath.Join(rootDir, "logs", "repo1"), "")
+	require.NoError(t, te.server.db.Create(&model.Repo{Name: "old"}).Error)
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo0.yaml"), "name: repo0"+testRepoConfig)
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo1.yaml"), "name: repo1"+testRepoConfig)
+
+	_, err := te.server.reloadAllRepos(te.server.db, te.server.logger, reloadOptions{
+		trigger: api.ReloadTriggerAPI,
+		atomic:  true,
+	})
+	require.Error(t, err)
+	require.Zero(t, countRepos(t, te, "repo0"))
+	require.EqualValues(t, 1, countRepos(t, te, "old"))
+	require.Zero(t, te.server.repoSchedules.Count())
+}
+
+func TestReloadWithDefaults(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+	}
+	testutils.WriteFile(t, filepath.Join(rootDir, "defaults.yaml"), `
+defaults:
+  storageDir: /tmp
+templates:
+  alpine:
+    image: alpine:latest
+    cron: "* * * * *"
+`)
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo0.yaml"), "name: repo0\nextends: alpine")
+	cli := te.RESTClient()
+	resp, err := cli.R().Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	var repo model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "repo0"}).First(&repo).Error)
+	require.Equal(t, "alpine:latest", repo.Image)
+	require.Equal(t, "/tmp", repo.StorageDir)
+	// defaults.yaml is not a repo.
+	require.Zero(t, countRepos(t, te, "defaults"))
+
+	var rendered map[string]any
+	resp, err = cli.R().SetQueryParam("rendered", "true").SetResult(&rendered).Get("/repos/repo0")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Equal(t, map[string]any{
+		"name":       "repo0",
+		"image":      "alpine:latest",
+		"cron":       "* * * * *",
+		"storageDir": "/tmp",
+	}, rendered)
+
+	resp, err = cli.R().SetQueryParam("rendered", "true").Get("/repos/repo1")
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode())
+}
+
+func TestReloadStrictRepoConfig(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+	}
+	configPath := filepath.Join(rootDir, "repo0.yaml")
+	testutils.WriteFile(t, configPath, "name: repo0"+testRepoConfig+"storagedir: /tmp\nmirrorName: repo0\n")
+	cli := te.RESTClient()
+
+	te.server.config.StrictRepoConfig = strictWarn
+	var result api.ReloadResult
+	resp, err := cli.R().SetResult(&result).Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Equal(t, []api.ConfigError{
+		{File: configPath, Line: 5, Error: `unknown field "storagedir", did you mean "storageDir"?`},
+		{File: configPath, Line: 6, Error: `unknown field "mirrorName"`},
+	}, result.Warnings)
+
+	result = api.ReloadResult{}
+	resp, err = cli.R().SetResult(&result).Post("/repos/repo0")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Len(t, result.Warnings, 2)
+
+	te.server.config.StrictRepoConfig = strictError
+	resp, err = cli.R().Post("/repos/repo0")
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode())
+	require.Contains(t, resp.String(), configPath+":5:")
+
+	resp, err = cli.R().Post("/repos")
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode())
+
+	te.server.config.StrictRepoConfig = strictOff
+	result = api.ReloadResult{}
+	resp, err = cli.R().SetResult(&result).Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.Empty(t, result.Warnings)
+}
+
+func TestDiffRepo(t *testing.T) {
+	changes, err := diffRepo(
+		&model.Repo{Name: "a", Envs: model.StringMap{"A": "1"}, Retry: 1},
+		&model.Repo{Name: "a", Envs: model.StringMap{"A": "2"}, Retry: 1, RetryPolicy: &model.RetryPolicy{MaxAttempts: 2}},
+	)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, "envs", changes[0].Field)
+	require.Equal(t, map[string]any{"A": "2"}, changes[0].New)
+	require.Equal(t, "retryPolicy", changes[1].Field)
+	require.Nil(t, changes[1].Old)
+}
+
+func TestWatchRepoConfigs(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{rootDir},
+		WatchDebounce: 100 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	require.NoError(t, te.server.watchRepoConfigs(ctx))
+
+	testutils.WriteFile(t, filepath.Join(rootDir, "repo0.yaml"), "name: repo0"+testRepoConfig)
+	// Files that are not configs are ignored.
+	testutils.WriteFile(t, filepath.Join(rootDir, "README"), "")
+	testutils.PollUntilTimeout(t, 5*time.Second, func() bool {
+		return countRepos(t, te, "repo0") == 1
+	})
+	require.True(t, te.server.repoSchedules.Has("repo0"))
+
+	require.NoError(t, os.Remove(filepath.Join(rootDir, "repo0.yaml")))
+	testutils.PollUntilTimeout(t, 5*time.Second, func() bool {
+		return countRepos(t, te, "repo0") == 0
+	})
+	result := te.server.lastReload.Load()
+	require.NotNil(t, result)
+	require.Equal(t, []string{"repo0"}, result.Removed)
+}
@@ -3,23 +3,23 @@ package server
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/cpuguy83/go-docker/errdefs"
-	"github.com/cpuguy83/go-docker/image"
 	"github.com/labstack/echo/v4"
 	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"sigs.k8s.io/yaml"
 
 	"github.com/ustclug/Yuki/pkg/api"
 	"github.com/ustclug/Yuki/pkg/model"
-	"github.com/ustclug/Yuki/pkg/set"
+	"github.com/ustclug/Yuki/pkg/repoconfig"
 )
 
 func (s *Server) handlerListRepos(c echo.Context) error {
@@ -57,6 +57,21 @@ func (s *Server) handlerGetRepo(c echo.Context) error {
 		return err
 	}
 
+	if c.QueryParam("rendered") == "true" {
+		loader, err := newConfigLoader(s.config.RepoConfigDir)
+		if err != nil {
+			return err
+		}
+		cfg, err := loader.Render(name + suffixYAML)
+		if err != nil {
+			if errors.Is(err, repoconfig.ErrNotFound) {
+				return newHTTPError(http.StatusNotFound, fmt.Sprintf("File not found: %q", name+suffixYAML))
+			}
+			return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to render config: %v", err))
+		}
+		return c.JSON(http.StatusOK, cfg)
+	}
+
 	var repo model.Repo
 	res := s.getDB(c).
 		Where(model.Repo{Name: name}).
@@ -99,68 +114,112 @@ func (s *Server) handlerRemoveRepo(c echo.Context) error {
 	if res.RowsAffected == 0 {
 		return newHTTPError(http.StatusNotFound, "Repo not found")
 	}
+	s.events.publish(api.Event{
+		Type: api.EventRepoRemoved,
+		Repo: name,
+	})
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (s *Server) loadRepo(c echo.Context, logger *slog.Logger, dirs []string, file string) (*model.Repo, error) {
+// loadRepo loads the config file into the database. The unknown and mistyped fields are returned as warnings.
+func (s *Server) loadRepo(db *gorm.DB, logger *slog.Logger, dirs []string, file string) (*model.Repo, []api.ConfigError, error) {
 	l := logger.With(slog.String("config", file))
 
-	var repo model.Repo
-	errn := len(dirs)
-	for _, dir := range dirs {
-		data, err := os.ReadFile(filepath.Join(dir, file))
-		if err != nil {
-			errn--
-			if errn > 0 && os.IsNotExist(err) {
-				continue
-			} else {
-				return nil, newHTTPError(http.StatusNotFound, fmt.Sprintf("File not found: %q", file))
-			}
-		}
-		err = yaml.Unmarshal(data, &repo)
-		if err != nil {
-			return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to parse config: %q: %v", file, err))
-		}
+	loader, err := newConfigLoader(dirs)
+	if err != nil {
+		return nil, nil, err
 	}
+	warnings, err := s.checkConfigFields(l, loader.CheckDefaultsFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileWarnings, err := s.checkConfigFields(l, func() ([]repoconfig.FieldIssue, error) {
+		return loader.CheckFields(file)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings = append(warnings, fileWarnings...)
+	repo, err := loadRepoConfig(loader, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	schedule, err := s.validateRepo(repo, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.saveRepo(db, l, repo, schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.repoSchedules.Set(repo.Name, schedule)
+	return repo, warnings, nil
+}
 
-	err := s.e.Validator.Validate(&repo)
+func newConfigLoader(dirs []string) (*repoconfig.Loader, error) {
+	loader, err := repoconfig.NewLoader(dirs)
 	if err != nil {
-		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid config: %q: %v", file, err))
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to load defaults: %v", err))
 	}
+	return loader, nil
+}
 
-	_, err = image.ParseRef(repo.Image)
+// loadRepoConfig renders the config file with defaults and templates.
+func loadRepoConfig(loader *repoconfig.Loader, file string) (*model.Repo, error) {
+	repo, err := loader.Load(file)
 	if err != nil {
-		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid image: %q: %v", repo.Image, err))
+		if errors.Is(err, repoconfig.ErrNotFound) {
+			return nil, newHTTPError(http.StatusNotFound, fmt.Sprintf("File not found: %q", file))
+		}
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to parse config: %q: %v", file, err))
 	}
+	repo.ConfigFile = file
+	return repo, nil
+}
 
-	schedule, err := cron.ParseStandard(repo.Cron)
+// validateRepo validates the repo and returns its schedule. src is the origin of the repo used in error messages.
+func (s *Server) validateRepo(repo *model.Repo, src string) (cron.Schedule, error) {
+	schedule, err := repoconfig.Validate(s.e.Validator.Validate, repo, src)
 	if err != nil {
-		return nil, newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid cron: %q: %v", repo.Cron, err))
+		return nil, newHTTPError(http.StatusBadRequest, err.Error())
 	}
-	s.repoSchedules.Set(repo.Name, schedule)
+	return schedule, nil
+}
 
+// saveRepo saves the validated repo into the database and creates its RepoMeta if necessary.
+// The caller should update repoSchedules after the repo is saved.
+func (s *Server) saveRepo(db *gorm.DB, l *slog.Logger, repo *model.Repo, schedule cron.Schedule) error {
 	envUpstream := getEnvUpstream(repo.Envs)
 
 	logDir := filepath.Join(s.config.RepoLogsDir, repo.Name)
-	err = os.MkdirAll(logDir, 0o755)
+	err := os.MkdirAll(logDir, 0o755)
 	if err != nil {
-		return nil, newHTTPError(http.StatusInternalServerError, fmt.Sprintf("Fail to create log dir: %q", logDir))
+		return newHTTPError(http.StatusInternalServerError, fmt.Sprintf("Fail to create log dir: %q", logDir))
 	}
 
-	db := s.getDB(c)
+	var prev model.Repo
+	res := db.Select("cron").Where(model.Repo{Name: repo.Name}).Limit(1).Find(&prev)
+	if res.Error != nil {
+		const msg = "Fail to get Repo"
+		l.Error(msg, slogErrAttr(res.Error))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
 	err = db.
 		Clauses(clause.OnConflict{UpdateAll: true}).
-		Create(&repo).Error
+		Create(repo).Error
 	if err != nil {
 		const msg = "Fail to create Repo"
 		l.Error(msg, slogErrAttr(err))
-		return nil, newHTTPError(http.StatusInternalServerError, msg)
+		return newHTTPError(http.StatusInternalServerError, msg)
 	}
 
-	nextRun := schedule.Next(time.Now()).Unix()
+	now := time.Now()
+	nextRun := schedule.Next(now).Unix()
 
-	doUpdatesOnConflictAssignment := map[string]any{
-		"next_run": nextRun,
+	doUpdatesOnConflictAssignment := map[string]any{}
+	// The schedule is kept unless the cron is changed.
+	if res.RowsAffected == 0 || prev.Cron != repo.Cron {
+		doUpdatesOnConflictAssignment["next_run"] = scheduledNextRun(nextRun, now)
 	}
 	if envUpstream != "" {
 		doUpdatesOnConflictAssignment["upstream"] = envUpstream
@@ -169,6 +228,7 @@ func (s *Server) loadRepo(c echo.Context, logger *slog.Logger, dirs []string, fi
 	err = db.
 		Clauses(clause.OnConflict{
 			DoUpdates: clause.Assignments(doUpdatesOnConflictAssignment),
+			DoNothing: len(doUpdatesOnConflictAssignment) == 0,
 		}).
 		Create(&model.RepoMeta{
 			Name:     repo.Name,
@@ -179,67 +239,76 @@ func (s *Server) loadRepo(c echo.Context, logger *slog.Logger, dirs []string, fi
 	if err != nil {
 		const msg = "Fail to create RepoMeta"
 		l.Error(msg, slogErrAttr(err))
-		return nil, newHTTPError(http.StatusInternalServerError, msg)
+		return newHTTPError(http.StatusInternalServerError, msg)
 	}
-	return &repo, nil
+	return nil
 }
 
 func (s *Server) handlerReloadAllRepos(c echo.Context) error {
 	l := getLogger(c)
 	l.Debug("Invoked")
 
-	var repoNames []string
-	db := s.getDB(c)
-	err := db.Model(&model.Repo{}).Pluck("name", &repoNames).Error
+	dryRun := c.QueryParam("dryRun") == "true"
+	result, err := s.reloadAllRepos(s.getDB(c), l, reloadOptions{
+		trigger: api.ReloadTriggerAPI,
+		// Report all the invalid configs in dry-run mode.
+		keepGoing: dryRun,
+		dryRun:    dryRun,
+		atomic:    c.QueryParam("atomic") == "true",
+	})
 	if err != nil {
-		const msg = "Fail to list Repos"
-		l.Error(msg, slogErrAttr(err))
-		return newHTTPError(http.StatusInternalServerError, msg)
+		return err
 	}
+	return c.JSON(http.StatusOK, result)
+}
 
-	l.Debug("Reloading all repos")
-	toDelete := set.New(repoNames...)
-	for _, dir := range s.config.RepoConfigDir {
-		infos, err := os.ReadDir(dir)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				l.Warn("Fail to list dir", slogErrAttr(err), slog.String("dir", dir))
-			}
-			continue
-		}
-		for _, info := range infos {
-			fileName := info.Name()
-			if info.IsDir() || fileName[0] == '.' || !strings.HasSuffix(fileName, suffixYAML) {
-				continue
-			}
-			repo, err := s.loadRepo(c, l, s.config.RepoConfigDir, fileName)
-			if err != nil {
-				return err
-			}
-			toDelete.Del(repo.Name)
-		}
+func (s *Server) handlerGetReloadStatus(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	result := s.lastReload.Load()
+	if result == nil {
+		return newHTTPError(http.StatusNotFound, "No reload yet")
 	}
+	return c.JSON(http.StatusOK, result)
+}
 
-	toDeleteNames := toDelete.ToList()
-	l.Debug("Deleting unnecessary repos", slog.Any("repos", toDeleteNames))
-	err = db.Where("name IN ?", toDeleteNames).Delete(&model.Repo{}).Error
+func (s *Server) handlerReloadRepo(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = s.getDB(c).Model(&model.Repo{}).Where(model.Repo{Name: name}).Count(&count).Error
 	if err != nil {
-		const msg = "Fail to delete Repos"
+		const msg = "Fail to get Repo"
 		l.Error(msg, slogErrAttr(err))
 		return newHTTPError(http.StatusInternalServerError, msg)
 	}
-	err = db.Where("name IN ?", toDeleteNames).Delete(&model.RepoMeta{}).Error
+	repo, warnings, err := s.loadRepo(s.getDB(c), l.With(slog.String("repo", name)), s.config.RepoConfigDir, name+suffixYAML)
 	if err != nil {
-		const msg = "Fail to delete RepoMetas"
-		l.Error(msg, slogErrAttr(err))
+		return err
 	}
-	for name := range toDelete {
-		s.repoSchedules.Remove(name)
+	result := api.ReloadResult{
+		Time:     time.Now().Unix(),
+		Trigger:  api.ReloadTriggerAPI,
+		Loaded:   1,
+		Warnings: warnings,
 	}
-	return c.NoContent(http.StatusNoContent)
+	if count == 0 {
+		result.Added = []string{repo.Name}
+		s.events.publish(api.Event{
+			Type: api.EventRepoAdded,
+			Repo: repo.Name,
+		})
+	}
+	return c.JSON(http.StatusOK, result)
 }
 
-func (s *Server) handlerReloadRepo(c echo.Context) error {
+func (s *Server) handlerApplyRepo(c echo.Context) error {
 	l := getLogger(c)
 	l.Debug("Invoked")
 
@@ -247,11 +316,136 @@ func (s *Server) handlerReloadRepo(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	_, err = s.loadRepo(c, l.With(slog.String("repo", name)), s.config.RepoConfigDir, name+suffixYAML)
+	l = l.With(slog.String("repo", name))
+
+	data, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to read body: %v", err))
+	}
+	var cfg map[string]any
+	// JSON is a subset of YAML so that both are accepted.
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to parse config: %v", err))
+	}
+	if cfg == nil {
+		cfg = map[string]any{}
+	}
+	if cfgName, ok := cfg["name"]; !ok || cfgName == "" {
+		cfg["name"] = name
+	} else if cfgName != any(name) {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Name mismatch: %q != %q", fmt.Sprint(cfgName), name))
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// The config is rendered and checked in the same way as the config files.
+	loader, err := newConfigLoader(s.config.RepoConfigDir)
 	if err != nil {
 		return err
 	}
-	return c.NoContent(http.StatusNoContent)
+	_, err = s.checkConfigFields(l, loader.CheckDefaultsFields)
+	if err != nil {
+		return err
+	}
+	_, err = s.checkConfigFields(l, func() ([]repoconfig.FieldIssue, error) {
+		return repoconfig.CheckFields(data)
+	})
+	if err != nil {
+		return err
+	}
+	repo, err := loader.LoadConfig(cfg)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Fail to parse config: %v", err))
+	}
+	schedule, err := s.validateRepo(repo, name)
+	if err != nil {
+		return err
+	}
+	persist := c.QueryParam("persist") == "true"
+	repo.APIManaged = !persist
+	if persist {
+		repo.ConfigFile = name + suffixYAML
+	}
+
+	var count int64
+	err = s.getDB(c).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.Repo{}).Where(model.Repo{Name: name}).Count(&count).Error
+		if err != nil {
+			const msg = "Fail to get Repo"
+			l.Error(msg, slogErrAttr(err))
+			return newHTTPError(http.StatusInternalServerError, msg)
+		}
+		err = s.saveRepo(tx, l, repo, schedule)
+		if err != nil {
+			return err
+		}
+		if !persist {
+			return nil
+		}
+		// The file is written last so that the saving is rolled back if the file cannot be written.
+		err = s.persistRepo(name, cfg)
+		if errors.Is(err, errOverridden) {
+			return newHTTPError(http.StatusConflict, fmt.Sprintf("Fail to persist Repo: %v", err))
+		}
+		if err != nil {
+			const msg = "Fail to persist Repo"
+			l.Error(msg, slogErrAttr(err))
+			return newHTTPError(http.StatusInternalServerError, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.repoSchedules.Set(repo.Name, schedule)
+	if count > 0 {
+		return c.JSON(http.StatusOK, repo)
+	}
+	s.events.publish(api.Event{
+		Type: api.EventRepoAdded,
+		Repo: repo.Name,
+	})
+	return c.JSON(http.StatusCreated, repo)
+}
+
+// persistRepo writes the config of the repo as <name>.yaml into the first config dir.
+// The config is written as submitted, so that it still extends the templates and the defaults.
+// It fails with errOverridden if a later config dir has the same file, which would take precedence on reload.
+func (s *Server) persistRepo(name string, cfg map[string]any) error {
+	fileName := name + suffixYAML
+	for _, d := range s.config.RepoConfigDir[1:] {
+		_, err := os.Stat(filepath.Join(d, fileName))
+		if err == nil {
+			return fmt.Errorf("%s: %w", filepath.Join(d, fileName), errOverridden)
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	dir := s.config.RepoConfigDir[0]
+	tmp, err := os.CreateTemp(dir, "."+name+"*"+suffixYAML)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = tmp.Chmod(0o644)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// Replace the file atomically so that a reload never sees a partial config.
+	return os.Rename(tmp.Name(), filepath.Join(dir, fileName))
 }
 
 func (s *Server) handlerSyncRepo(c echo.Context) error {
@@ -265,7 +459,7 @@ func (s *Server) handlerSyncRepo(c echo.Context) error {
 	l = l.With(slog.String("repo", name))
 
 	debug := len(c.QueryParam("debug")) > 0
-	err = s.syncRepo(c.Request().Context(), name, debug)
+	err = s.syncRepo(c.Request().Context(), name, debug, model.SyncTriggerManual)
 	if err != nil {
 		if errors.Is(err, errNotFound) {
 			return newHTTPError(http.StatusNotFound, "Repo not found")
@@ -279,3 +473,171 @@ func (s *Server) handlerSyncRepo(c echo.Context) error {
 	}
 	return c.NoContent(http.StatusCreated)
 }
+
+func (s *Server) handlerListRepoHistory(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+
+	var req api.ListSyncRecordsRequest
+	err = (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid query: %v", err))
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = defaultPageSize
+	}
+	if req.PageSize > maxPageSize {
+		req.PageSize = maxPageSize
+	}
+
+	var (
+		total   int64
+		records []model.SyncRecord
+	)
+	db := s.getDB(c)
+	err = db.Model(&model.SyncRecord{}).Where(model.SyncRecord{Name: name}).Count(&total).Error
+	if err != nil {
+		const msg = "Fail to count SyncRecords"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	err = db.
+		Where(model.SyncRecord{Name: name}).
+		Order("id DESC").
+		Offset((req.Page - 1) * req.PageSize).
+		Limit(req.PageSize).
+		Find(&records).Error
+	if err != nil {
+		const msg = "Fail to list SyncRecords"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+
+	resp := api.ListSyncRecordsResponse{
+		Total:   total,
+		Records: make([]api.SyncRecord, len(records)),
+	}
+	for i, r := range records {
+		resp.Records[i] = api.SyncRecord{
+			ID:          r.ID,
+			Name:        r.Name,
+			StartedAt:   r.StartedAt,
+			FinishedAt:  r.FinishedAt,
+			ExitCode:    r.ExitCode,
+			TimedOut:    r.TimedOut,
+			Cancelled:   r.Cancelled,
+			SizeBefore:  r.SizeBefore,
+			SizeAfter:   r.SizeAfter,
+			Upstream:    r.Upstream,
+			Debug:       r.Debug,
+			Trigger:     r.Trigger,
+			Image:       r.Image,
+			ImageDigest: r.ImageDigest,
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handlerCancelSyncRepo(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+	l = l.With(slog.String("repo", name))
+
+	err = s.cancelSync(name)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return newHTTPError(http.StatusNotFound, "Repo is not syncing")
+		}
+		const msg = "Fail to cancel sync"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) handlerPauseRepo(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+	l = l.With(slog.String("repo", name))
+
+	var req api.PauseRepoRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return err
+	}
+	err = c.Validate(&req)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Until > 0 && req.Until <= time.Now().Unix() {
+		return newHTTPError(http.StatusBadRequest, "until must be in the future")
+	}
+
+	res := s.getDB(c).
+		Model(&model.RepoMeta{}).
+		Where(model.RepoMeta{Name: name}).
+		Updates(map[string]any{
+			"paused":        true,
+			"paused_reason": req.Reason,
+			"paused_until":  req.Until,
+		})
+	if res.Error != nil {
+		const msg = "Fail to pause Repo"
+		l.Error(msg, slogErrAttr(res.Error))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	if res.RowsAffected == 0 {
+		return newHTTPError(http.StatusNotFound, "Repo not found")
+	}
+	s.queue.remove(name)
+	l.Info("Repo paused", slog.String("reason", req.Reason), slog.Int64("until", req.Until))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) handlerResumeRepo(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	name, err := getRepoNameFromRoute(c)
+	if err != nil {
+		return err
+	}
+	l = l.With(slog.String("repo", name))
+
+	res := s.getDB(c).
+		Model(&model.RepoMeta{}).
+		Where(model.RepoMeta{Name: name}).
+		Updates(map[string]any{
+			"paused":        false,
+			"paused_reason": "",
+			"paused_until":  0,
+		})
+	if res.Error != nil {
+		const msg = "Fail to resume Repo"
+		l.Error(msg, slogErrAttr(res.Error))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	if res.RowsAffected == 0 {
+		return newHTTPError(http.StatusNotFound, "Repo not found")
+	}
+	l.Info("Repo resumed")
+	return c.NoContent(http.StatusNoContent)
+}
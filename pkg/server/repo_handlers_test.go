@@ -1,16 +1,20 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/go-resty/resty/v2"
 	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/docker"
 	"github.com/ustclug/Yuki/pkg/model"
 	testutils "github.com/ustclug/Yuki/test/utils"
 )
@@ -91,6 +95,9 @@ storageDir: /tmp
 image: ubuntu
 envs:
   $UPSTREAM: http://bar.com
+retryPolicy:
+  maxAttempts: 3
+  baseDelay: 10m
 `)
 
 	cli := te.RESTClient()
@@ -108,6 +115,8 @@ envs:
 	require.Equal(t, "ubuntu", repos[0].Image)
 	require.Equal(t, "* * * * *", repos[0].Cron)
 	require.NotEmpty(t, repos[0].Envs)
+	require.NotNil(t, repos[0].RetryPolicy)
+	require.Equal(t, model.Duration(10*time.Minute), repos[0].RetryPolicy.BaseDelay)
 
 	require.Equal(t, "repo1", repos[1].Name)
 	require.Equal(t, "alpine:latest", repos[1].Image)
@@ -121,6 +130,243 @@ envs:
 	require.Equal(t, "repo1", metas[1].Name)
 }
 
+func TestHandlerReloadAllReposKeepsPendingRetry(t *testing.T) {
+	te := NewTestEnv(t)
+	cfgDir := t.TempDir()
+	te.server.config = Config{
+		RepoLogsDir:   t.TempDir(),
+		RepoConfigDir: []string{cfgDir},
+	}
+	testutils.WriteFile(t, filepath.Join(cfgDir, "repo0.yaml"), `
+name: repo0
+cron: "0 0 1 1 *"
+image: "alpine:latest"
+storageDir: /tmp
+`)
+	cli := te.RESTClient()
+	resp, err := cli.R().Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	retryAt := time.Now().Add(time.Minute).Unix()
+	require.NoError(t, te.server.db.
+		Where(model.RepoMeta{Name: "repo0"}).
+		Updates(&model.RepoMeta{RetryAttempt: 1, NextRun: retryAt}).Error)
+	resp, err = cli.R().Post("/repos")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	meta := model.RepoMeta{Name: "repo0"}
+	require.NoError(t, te.server.db.Take(&meta).Error)
+	require.Equal(t, retryAt, meta.NextRun)
+	require.Equal(t, 1, meta.RetryAttempt)
+}
+
+func TestHandlerApplyRepo(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	cfgDir := filepath.Join(rootDir, "cfg")
+	require.NoError(t, os.Mkdir(cfgDir, 0o755))
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{cfgDir},
+	}
+	cli := te.RESTClient()
+
+	// YAML body without name.
+	resp, err := cli.R().
+		SetHeader("Content-Type", "application/yaml").
+		SetBody(`
+cron: "* * * * *"
+image: "alpine:latest"
+storageDir: /tmp
+`).
+		Put("/repos/repo0")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	require.True(t, te.server.repoSchedules.Has("repo0"))
+
+	var meta model.RepoMeta
+	require.NoError(t, te.server.db.Where(model.RepoMeta{Name: "repo0"}).First(&meta).Error)
+	require.NotZero(t, meta.NextRun)
+	_, err = os.Stat(filepath.Join(cfgDir, "repo0.yaml"))
+	require.True(t, os.IsNotExist(err), "should not be persisted")
+
+	// JSON body and persist.
+	var repo model.Repo
+	resp, err = cli.R().
+		SetBody(model.Repo{
+			Name:       "repo0",
+			Cron:       "@every 1h",
+			Image:      "ubuntu",
+			StorageDir: "/tmp",
+		}).
+		SetQueryParam("persist", "true").
+		SetResult(&repo).
+		Put("/repos/repo0")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	require.Equal(t, "ubuntu", repo.Image)
+
+	var saved model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "repo0"}).First(&saved).Error)
+	require.Equal(t, "@every 1h", saved.Cron)
+
+	// The persisted file can be reloaded.
+	require.NoError(t, te.server.db.Delete(&saved).Error)
+	resp, err = cli.R().Post("/repos/repo0")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "repo0"}).First(&saved).Error)
+	require.Equal(t, "ubuntu", saved.Image)
+
+	for body, msg := range map[string]string{
+		`{"name": "repo1", "cron": "* * * * *", "image": "alpine", "storageDir": "/tmp"}`: "Name mismatch",
+		`{"cron": "* * * * *", "image": "alpine", "storageDir": "/no/such/dir"}`:          "Invalid config",
+		`{"cron": "invalid", "image": "alpine", "storageDir": "/tmp"}`:                    "Invalid cron",
+		`{"cron": "* * * * *", "image": "a b", "storageDir": "/tmp"}`:                     "Invalid image",
+	} {
+		resp, err = cli.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Put("/repos/repo0")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode(), body)
+		require.Contains(t, resp.String(), msg)
+	}
+}
+
+func TestHandlerApplyRepoTemplates(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	cfgDir := filepath.Join(rootDir, "cfg")
+	overrideDir := filepath.Join(rootDir, "override")
+	require.NoError(t, os.Mkdir(cfgDir, 0o755))
+	require.NoError(t, os.Mkdir(overrideDir, 0o755))
+	te.server.config = Config{
+		RepoLogsDir:      filepath.Join(rootDir, "logs"),
+		RepoConfigDir:    []string{cfgDir, overrideDir},
+		StrictRepoConfig: strictError,
+	}
+	testutils.WriteFile(t, filepath.Join(cfgDir, "defaults.yaml"), `
+defaults:
+  cron: "@every 1h"
+  storageDir: /tmp
+templates:
+  rsync:
+    image: ustcmirror/rsync:latest
+`)
+	cli := te.RESTClient()
+	apply := func(name, body string) *resty.Response {
+		resp, err := cli.R().
+			SetHeader("Content-Type", "application/yaml").
+			SetQueryParam("persist", "true").
+			SetBody(body).
+			Put("/repos/" + name)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// The defaults and the templates are applied.
+	resp := apply("repo0", "extends: rsync\n")
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	var repo model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "repo0"}).First(&repo).Error)
+	require.Equal(t, "ustcmirror/rsync:latest", repo.Image)
+	require.Equal(t, "@every 1h", repo.Cron)
+	// The persisted config still extends the template.
+	data, err := os.ReadFile(filepath.Join(cfgDir, "repo0.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "extends: rsync")
+	require.NotContains(t, string(data), "image:")
+
+	// The unknown fields are rejected in the strict mode.
+	resp = apply("repo1", "extends: rsync\nimgae: alpine\n")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	require.Contains(t, resp.String(), "imgae")
+
+	// Nothing is saved if the config cannot be persisted.
+	testutils.WriteFile(t, filepath.Join(overrideDir, "repo2.yaml"), "image: alpine\n")
+	resp = apply("repo2", "extends: rsync\n")
+	require.Equal(t, http.StatusConflict, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	var count int64
+	require.NoError(t, te.server.db.Model(&model.Repo{}).Where(model.Repo{Name: "repo2"}).Count(&count).Error)
+	require.Zero(t, count)
+	require.False(t, te.server.repoSchedules.Has("repo2"))
+}
+
+func TestHandlerApplyRepoReloadAll(t *testing.T) {
+	te := NewTestEnv(t)
+	rootDir := t.TempDir()
+	cfgDir := filepath.Join(rootDir, "cfg")
+	overrideDir := filepath.Join(rootDir, "override")
+	require.NoError(t, os.Mkdir(cfgDir, 0o755))
+	require.NoError(t, os.Mkdir(overrideDir, 0o755))
+	te.server.config = Config{
+		RepoLogsDir:   filepath.Join(rootDir, "logs"),
+		RepoConfigDir: []string{cfgDir, overrideDir},
+	}
+	cli := te.RESTClient()
+	apply := func(name string, persist bool) *resty.Response {
+		req := cli.R().SetBody(model.Repo{
+			Cron:       "@every 1h",
+			Image:      "alpine:latest",
+			StorageDir: "/tmp",
+		})
+		if persist {
+			req.SetQueryParam("persist", "true")
+		}
+		resp, err := req.Put("/repos/" + name)
+		require.NoError(t, err)
+		return resp
+	}
+	reloadAll := func() api.ReloadResult {
+		var result api.ReloadResult
+		resp, err := cli.R().SetResult(&result).Post("/repos")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		return result
+	}
+
+	resp := apply("api-only", false)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	testutils.WriteFile(t, filepath.Join(cfgDir, "other.yaml"), `
+name: other
+cron: "@every 1h"
+image: alpine:latest
+storageDir: /tmp
+`)
+
+	// The repo applied through the API is kept by the reloads of all repos.
+	result := reloadAll()
+	require.Equal(t, []string{"other"}, result.Added)
+	require.Empty(t, result.Removed)
+	require.True(t, te.server.repoSchedules.Has("api-only"))
+	var repo model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "api-only"}).First(&repo).Error)
+	require.True(t, repo.APIManaged)
+
+	// Once persisted, the repo follows its config file.
+	resp = apply("api-only", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	var persisted model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "api-only"}).First(&persisted).Error)
+	require.False(t, persisted.APIManaged)
+	require.NoError(t, os.Remove(filepath.Join(cfgDir, "api-only.yaml")))
+	result = reloadAll()
+	require.Equal(t, []string{"api-only"}, result.Removed)
+
+	// The persisted config would be overridden by the later config dir.
+	testutils.WriteFile(t, filepath.Join(overrideDir, "other.yaml"), "image: ubuntu:latest\n")
+	resp = apply("other", true)
+	require.Equal(t, http.StatusConflict, resp.StatusCode(), "Unexpected response: %s", resp.Body())
+	_, err := os.Stat(filepath.Join(cfgDir, "other.yaml"))
+	require.NoError(t, err)
+	var other model.Repo
+	require.NoError(t, te.server.db.Where(model.Repo{Name: "other"}).First(&other).Error)
+	require.Equal(t, "alpine:latest", other.Image)
+}
+
 func TestHandlerSyncRepo(t *testing.T) {
 	te := NewTestEnv(t)
 	name := te.RandomString()
@@ -153,6 +399,151 @@ func TestHandlerSyncRepo(t *testing.T) {
 	require.Empty(t, meta.ExitCode, "ExitCode")
 	require.NotEmpty(t, meta.LastSuccess, "LastSuccess")
 	require.NotEmpty(t, meta.NextRun, "NextRun")
+
+	var records []model.SyncRecord
+	require.NoError(t, te.server.db.Where(model.SyncRecord{Name: name}).Find(&records).Error)
+	require.Len(t, records, 1)
+	require.Equal(t, model.SyncTriggerManual, records[0].Trigger)
+	require.Equal(t, meta.PrevRun, records[0].StartedAt)
+	require.NotEmpty(t, records[0].FinishedAt, "FinishedAt")
+	require.Empty(t, records[0].ExitCode, "ExitCode")
+}
+
+func TestHandlerCancelSyncRepo(t *testing.T) {
+	te := NewTestEnv(t)
+	name := te.RandomString()
+	require.NoError(t, te.server.db.Create(&model.Repo{
+		Name:       name,
+		Cron:       "@every 1h",
+		Image:      "alpine:latest",
+		StorageDir: "/data",
+	}).Error)
+	require.NoError(t, te.server.db.Create(&model.RepoMeta{Name: name}).Error)
+
+	cli := te.RESTClient()
+	resp, err := cli.R().Delete(fmt.Sprintf("/repos/%s/sync", name))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode(), "Cancelling idle repo does not return 404")
+
+	resp, err = cli.R().Post(fmt.Sprintf("/repos/%s/sync", name))
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	resp, err = cli.R().Delete(fmt.Sprintf("/repos/%s/sync", name))
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	meta := model.RepoMeta{
+		Name: name,
+	}
+	testutils.PollUntilTimeout(t, time.Minute, func() bool {
+		require.NoError(t, te.server.db.Take(&meta).Error)
+		return !meta.Syncing
+	})
+	require.Equal(t, api.ExitCodeCancelled, meta.ExitCode)
+	require.Empty(t, meta.LastSuccess, "LastSuccess")
+
+	var record model.SyncRecord
+	require.NoError(t, te.server.db.Where(model.SyncRecord{Name: name}).Take(&record).Error)
+	require.True(t, record.Cancelled)
+	require.Zero(t, te.server.cancelledSyncs.Count())
+}
+
+func TestCancelQueuedSync(t *testing.T) {
+	te := NewTestEnv(t)
+	name := te.RandomString()
+	require.NoError(t, te.server.db.Create(&model.RepoMeta{Name: name}).Error)
+	require.True(t, te.server.queue.push(name, "", 0))
+
+	now := time.Now().Unix()
+	require.NoError(t, te.server.cancelSync(name))
+	require.False(t, te.server.queue.remove(name))
+	meta := model.RepoMeta{Name: name}
+	require.NoError(t, te.server.db.Take(&meta).Error)
+	require.Greater(t, meta.NextRun, now, "Cancelled repo is due again")
+}
+
+// exitedContainerClient lists an extra container which has exited but not been removed yet.
+type exitedContainerClient struct {
+	docker.Client
+	summary docker.ContainerSummary
+}
+
+func (c *exitedContainerClient) ListContainersWithTimeout(running bool, timeout time.Duration) ([]docker.ContainerSummary, error) {
+	return []docker.ContainerSummary{c.summary}, nil
+}
+
+func (c *exitedContainerClient) StopContainerWithTimeout(id string, timeout time.Duration) error {
+	if id == c.summary.ID {
+		return nil
+	}
+	return c.Client.StopContainerWithTimeout(id, timeout)
+}
+
+func TestCancelExitedSync(t *testing.T) {
+	te := NewTestEnv(t)
+	name := te.RandomString()
+	require.NoError(t, te.server.db.Create(&model.Repo{
+		Name:       name,
+		Cron:       "@every 1h",
+		Image:      "alpine:latest",
+		StorageDir: "/data",
+	}).Error)
+	require.NoError(t, te.server.db.Create(&model.RepoMeta{Name: name}).Error)
+	te.server.dockerCli = &exitedContainerClient{
+		Client: te.server.dockerCli,
+		summary: docker.ContainerSummary{
+			ID:     "exited",
+			Labels: map[string]string{api.LabelRepoName: name},
+		},
+	}
+
+	// The container has exited by the time it is stopped, so the cancellation must not leak into the next sync.
+	require.NoError(t, te.server.cancelSync(name))
+	require.NoError(t, te.server.syncRepo(context.Background(), name, false, model.SyncTriggerManual))
+	meta := model.RepoMeta{Name: name}
+	testutils.PollUntilTimeout(t, time.Minute, func() bool {
+		require.NoError(t, te.server.db.Take(&meta).Error)
+		return !meta.Syncing
+	})
+	require.Zero(t, meta.ExitCode)
+
+	var record model.SyncRecord
+	require.NoError(t, te.server.db.Where(model.SyncRecord{Name: name}).Take(&record).Error)
+	require.False(t, record.Cancelled)
+}
+
+func TestHandlerListRepoHistory(t *testing.T) {
+	te := NewTestEnv(t)
+	name := te.RandomString()
+	records := make([]model.SyncRecord, 0, 5)
+	for i := 1; i <= 5; i++ {
+		records = append(records, model.SyncRecord{
+			Name:       name,
+			StartedAt:  int64(i * 100),
+			FinishedAt: int64(i*100 + 10),
+			ExitCode:   i % 2,
+		})
+	}
+	records = append(records, model.SyncRecord{Name: "other"})
+	require.NoError(t, te.server.db.Create(&records).Error)
+
+	var result api.ListSyncRecordsResponse
+	cli := te.RESTClient()
+	resp, err := cli.R().
+		SetResult(&result).
+		SetQueryParam("page", "2").
+		SetQueryParam("pageSize", "2").
+		Get(fmt.Sprintf("/repos/%s/history", name))
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	require.EqualValues(t, 5, result.Total)
+	require.Len(t, result.Records, 2)
+	// newest first
+	require.EqualValues(t, 300, result.Records[0].StartedAt)
+	require.EqualValues(t, 200, result.Records[1].StartedAt)
+	require.Equal(t, 1, result.Records[0].ExitCode)
 }
 
 func TestHandlerRemoveRepo(t *testing.T) {
@@ -181,3 +572,46 @@ func TestHandlerRemoveRepo(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 404, resp.StatusCode(), "Removing non-exist repo does not return 404")
 }
+
+func TestHandlerPauseResumeRepo(t *testing.T) {
+	te := NewTestEnv(t)
+	name := te.RandomString()
+	require.NoError(t, te.server.db.Create(&model.RepoMeta{Name: name}).Error)
+
+	cli := te.RESTClient()
+	until := time.Now().Add(time.Hour).Unix()
+	resp, err := cli.R().
+		SetBody(api.PauseRepoRequest{Reason: "maintenance", Until: until}).
+		Post(fmt.Sprintf("/repos/%s/pause", name))
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	var meta api.GetRepoMetaResponse
+	resp, err = cli.R().SetResult(&meta).Get("/metas/" + name)
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.True(t, meta.Paused)
+	require.Equal(t, "maintenance", meta.PausedReason)
+	require.Equal(t, until, meta.PausedUntil)
+
+	resp, err = cli.R().Post(fmt.Sprintf("/repos/%s/resume", name))
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	meta = api.GetRepoMetaResponse{}
+	resp, err = cli.R().SetResult(&meta).Get("/metas/" + name)
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+	require.False(t, meta.Paused)
+	require.Empty(t, meta.PausedReason)
+
+	resp, err = cli.R().
+		SetBody(api.PauseRepoRequest{Until: time.Now().Add(-time.Hour).Unix()}).
+		Post(fmt.Sprintf("/repos/%s/pause", name))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = cli.R().SetBody(api.PauseRepoRequest{}).Post("/repos/nonexist/pause")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode())
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ustclug/Yuki/pkg/docker"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const profileUnconfined = "unconfined"
+
+// securityOptions merges the SecurityOptions of the repo into container_security.
+func (s *Server) securityOptions(repo *model.Repo) model.SecurityOptions {
+	def := s.config.ContainerSecurity
+	opts := model.SecurityOptions{
+		ReadOnlyRootfs:  &def.ReadOnlyRootfs,
+		CapDrop:         def.CapDrop,
+		CapAdd:          def.CapAdd,
+		NoNewPrivileges: &def.NoNewPrivileges,
+		SeccompProfile:  def.SeccompProfile,
+		AppArmorProfile: def.AppArmorProfile,
+		Tmpfs:           def.Tmpfs,
+	}
+	sec := repo.Security
+	if sec == nil {
+		return opts
+	}
+	if sec.ReadOnlyRootfs != nil {
+		opts.ReadOnlyRootfs = sec.ReadOnlyRootfs
+	}
+	if sec.CapDrop != nil {
+		opts.CapDrop = sec.CapDrop
+	}
+	if sec.CapAdd != nil {
+		opts.CapAdd = sec.CapAdd
+	}
+	if sec.NoNewPrivileges != nil {
+		opts.NoNewPrivileges = sec.NoNewPrivileges
+	}
+	if len(sec.SeccompProfile) > 0 {
+		opts.SeccompProfile = sec.SeccompProfile
+	}
+	if len(sec.AppArmorProfile) > 0 {
+		opts.AppArmorProfile = sec.AppArmorProfile
+	}
+	// The tmpfs mounts of the repo are added to the default ones.
+	opts.Tmpfs = append(append([]string(nil), opts.Tmpfs...), sec.Tmpfs...)
+	return opts
+}
+
+// applySecurityOptions sets the security fields of the container config.
+func applySecurityOptions(cfg *docker.RunContainerConfig, opts model.SecurityOptions) error {
+	cfg.ReadOnlyRootfs = opts.ReadOnlyRootfs != nil && *opts.ReadOnlyRootfs
+	cfg.CapDrop = opts.CapDrop
+	cfg.CapAdd = opts.CapAdd
+	if opts.NoNewPrivileges != nil && *opts.NoNewPrivileges {
+		cfg.SecurityOpt = append(cfg.SecurityOpt, "no-new-privileges:true")
+	}
+	switch opts.SeccompProfile {
+	case "":
+	case profileUnconfined:
+		cfg.SecurityOpt = append(cfg.SecurityOpt, "seccomp="+profileUnconfined)
+	default:
+		// Like docker CLI, the content of the profile is sent to the docker daemon.
+		data, err := os.ReadFile(opts.SeccompProfile)
+		if err != nil {
+			return fmt.Errorf("read seccomp profile: %w", err)
+		}
+		cfg.SecurityOpt = append(cfg.SecurityOpt, "seccomp="+string(data))
+	}
+	if len(opts.AppArmorProfile) > 0 {
+		cfg.SecurityOpt = append(cfg.SecurityOpt, "apparmor="+opts.AppArmorProfile)
+	}
+	if len(opts.Tmpfs) > 0 {
+		cfg.Tmpfs = make(map[string]string, len(opts.Tmpfs))
+		for _, t := range opts.Tmpfs {
+			path, options, _ := strings.Cut(t, ":")
+			cfg.Tmpfs[path] = options
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const staleCheckInterval = time.Minute
+
+// maxStaleness returns the maxStaleness of the repo, which falls back to max_staleness. Zero means never stale.
+func (s *Server) maxStaleness(repo *model.Repo) time.Duration {
+	if repo.MaxStaleness > 0 {
+		return time.Duration(repo.MaxStaleness)
+	}
+	return s.config.MaxStaleness
+}
+
+// isStale reports whether the repo has not synced successfully within maxStaleness.
+// The repos that never succeed are measured from the time they are added.
+func isStale(meta model.RepoMeta, maxStaleness time.Duration, now time.Time) bool {
+	if maxStaleness <= 0 {
+		return false
+	}
+	since := meta.LastSuccess
+	if since == 0 {
+		since = meta.CreatedAt
+	}
+	return now.Sub(time.Unix(since, 0)) > maxStaleness
+}
+
+// checkStaleness updates the stale flags of the repos and reports the transitions.
+// The flags of the paused repos are left unchanged.
+func (s *Server) checkStaleness(now time.Time) {
+	var repos []model.Repo
+	err := s.db.Select("name", "max_staleness").Find(&repos).Error
+	if err != nil {
+		s.logger.Error("Fail to list Repos", slogErrAttr(err))
+		return
+	}
+	var metas []model.RepoMeta
+	err = s.db.Find(&metas).Error
+	if err != nil {
+		s.logger.Error("Fail to list RepoMetas", slogErrAttr(err))
+		return
+	}
+	metaByName := make(map[string]model.RepoMeta, len(metas))
+	for _, meta := range metas {
+		metaByName[meta.Name] = meta
+	}
+
+	for _, repo := range repos {
+		meta, ok := metaByName[repo.Name]
+		if !ok || meta.Paused {
+			continue
+		}
+		stale := isStale(meta, s.maxStaleness(&repo), now)
+		if stale == meta.Stale {
+			continue
+		}
+		l := s.logger.With(slog.String("repo", repo.Name))
+		err := s.db.
+			Model(&model.RepoMeta{}).
+			Where(model.RepoMeta{Name: repo.Name}).
+			Update("stale", stale).Error
+		if err != nil {
+			l.Error("Fail to update RepoMeta", slogErrAttr(err))
+			continue
+		}
+		lastSuccess := slog.Int64("lastSuccess", meta.LastSuccess)
+		event := api.Event{
+			Time: now.Unix(),
+			Repo: repo.Name,
+		}
+		p := api.WebhookPayload{
+			Repo:        repo.Name,
+			ExitCode:    meta.ExitCode,
+			Size:        meta.Size,
+			Upstream:    meta.Upstream,
+			LastSuccess: meta.LastSuccess,
+		}
+		if stale {
+			l.Warn("Repo is stale", lastSuccess, slog.Duration("maxStaleness", s.maxStaleness(&repo)))
+			event.Type = api.EventRepoStale
+			p.Event = api.NotifyOnStale
+		} else {
+			l.Info("Repo is no longer stale", lastSuccess)
+			event.Type = api.EventRepoFresh
+			p.Event = api.NotifyOnFresh
+		}
+		s.events.publish(event)
+		for _, n := range s.notifiers {
+			if n.match(p) {
+				go s.deliver(n, p)
+			}
+		}
+	}
+}
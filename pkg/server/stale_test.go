@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+	testutils "github.com/ustclug/Yuki/test/utils"
+)
+
+func TestCheckStaleness(t *testing.T) {
+	te := NewTestEnv(t)
+	rec := &webhookRecorder{}
+	hook := httptest.NewServer(rec)
+	t.Cleanup(hook.Close)
+	notifiers, err := newNotifiers([]NotifierConfig{
+		{
+			Name: "chat",
+			URL:  hook.URL,
+			On:   []string{api.NotifyOnStale, api.NotifyOnFresh},
+		},
+	})
+	require.NoError(t, err)
+	te.server.notifiers = notifiers
+	te.server.config.MaxStaleness = 48 * time.Hour
+
+	now := time.Now()
+	hoursAgo := func(h int) int64 {
+		return now.Add(-time.Duration(h) * time.Hour).Unix()
+	}
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "fresh"},
+		{Name: "stale"},
+		{Name: "custom", MaxStaleness: model.Duration(time.Hour)},
+		{Name: "paused"},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{
+		{Name: "fresh", LastSuccess: hoursAgo(1)},
+		{Name: "stale", LastSuccess: hoursAgo(49)},
+		{Name: "custom", LastSuccess: hoursAgo(2)},
+		{Name: "paused", LastSuccess: hoursAgo(49), Paused: true},
+	}).Error)
+
+	te.server.checkStaleness(now)
+	listStale := func(stale string) []string {
+		var metas []api.GetRepoMetaResponse
+		resp, err := te.RESTClient().R().SetQueryParam("stale", stale).SetResult(&metas).Get("/metas")
+		require.NoError(t, err)
+		require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+		names := make([]string, len(metas))
+		for i, m := range metas {
+			require.Equal(t, stale == "true", m.Stale)
+			names[i] = m.Name
+		}
+		return names
+	}
+	require.Equal(t, []string{"custom", "stale"}, listStale("true"))
+	require.Equal(t, []string{"fresh", "paused"}, listStale("false"))
+
+	resp, err := te.RESTClient().R().SetQueryParam("stale", "maybe").Get("/metas")
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode())
+
+	testutils.PollUntilTimeout(t, time.Minute, func() bool {
+		return len(rec.received()) == 2
+	})
+
+	// Only the transitions are reported.
+	te.server.checkStaleness(now)
+	require.NoError(t, te.server.db.
+		Model(&model.RepoMeta{}).
+		Where(model.RepoMeta{Name: "stale"}).
+		Update("last_success", now.Unix()).Error)
+	te.server.checkStaleness(now)
+	require.Equal(t, []string{"custom"}, listStale("true"))
+	testutils.PollUntilTimeout(t, time.Minute, func() bool {
+		return len(rec.received()) == 3
+	})
+	bodies := rec.received()
+	events := make(map[string]string, len(bodies))
+	for _, body := range bodies {
+		var p api.WebhookPayload
+		require.NoError(t, json.Unmarshal([]byte(body), &p))
+		if p.Repo == "stale" && p.Event == api.NotifyOnStale {
+			require.Equal(t, hoursAgo(49), p.LastSuccess)
+		}
+		events[p.Repo] += p.Event + ","
+	}
+	require.Equal(t, map[string]string{
+		"custom": "stale,",
+		"stale":  "stale,fresh,",
+	}, events)
+}
+
+func TestIsStale(t *testing.T) {
+	now := time.Now()
+	require.False(t, isStale(model.RepoMeta{}, 0, now))
+	require.True(t, isStale(model.RepoMeta{CreatedAt: now.Add(-2 * time.Hour).Unix()}, time.Hour, now))
+	require.False(t, isStale(model.RepoMeta{CreatedAt: now.Add(-2 * time.Hour).Unix(), LastSuccess: now.Unix()}, time.Hour, now))
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+const statusTemplateName = "status.html"
+
+// The states of the repos shown on the status page.
+const (
+	repoStateNew     = "new"
+	repoStateSuccess = "success"
+	repoStateFailed  = "failed"
+	repoStateSyncing = "syncing"
+	repoStatePaused  = "paused"
+)
+
+// statusPage is the data of the status page template.
+type statusPage struct {
+	Repos api.ListRepoMetasResponse
+	Now   time.Time
+}
+
+func repoState(meta api.GetRepoMetaResponse) string {
+	switch {
+	case meta.Paused:
+		return repoStatePaused
+	case meta.Syncing:
+		return repoStateSyncing
+	case meta.ExitCode != 0:
+		return repoStateFailed
+	case meta.LastSuccess > 0:
+		return repoStateSuccess
+	default:
+		return repoStateNew
+	}
+}
+
+// relativeTime formats the unix timestamp relative to now, e.g. "3 hours ago" or "in 5 minutes".
+func relativeTime(now time.Time, ts int64) string {
+	if ts <= 0 {
+		return ""
+	}
+	d := now.Sub(time.Unix(ts, 0))
+	if d >= 0 {
+		return strings.ToLower(units.HumanDuration(d)) + " ago"
+	}
+	return "in " + strings.ToLower(units.HumanDuration(-d))
+}
+
+var statusTemplateFuncs = template.FuncMap{
+	"state": repoState,
+	"size": func(size int64) string {
+		return units.BytesSize(float64(size))
+	},
+	"relTime": relativeTime,
+	"formatTime": func(ts int64) string {
+		if ts <= 0 {
+			return ""
+		}
+		return time.Unix(ts, 0).Format(time.RFC3339)
+	},
+}
+
+// newStatusTemplate parses the embedded templates of the status page.
+// The *.html files in dir, if any, override the embedded templates and blocks with the same names.
+func newStatusTemplate(dir string) (*template.Template, error) {
+	tmpl, err := template.New(statusTemplateName).Funcs(statusTemplateFuncs).ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if len(dir) == 0 {
+		return tmpl, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return tmpl, nil
+	}
+	tmpl, err = tmpl.ParseFiles(files...)
+	if err != nil {
+		return nil, fmt.Errorf("parse status templates: %w", err)
+	}
+	return tmpl, nil
+}
+
+func (s *Server) handlerStatusPage(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	metas, err := s.listRepoMetas(c)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = s.statusTmpl.ExecuteTemplate(&buf, statusTemplateName, statusPage{
+		Repos: metas,
+		Now:   time.Now(),
+	})
+	if err != nil {
+		const msg = "Fail to render status page"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
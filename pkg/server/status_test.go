@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/model"
+	testutils "github.com/ustclug/Yuki/test/utils"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Unix(10000, 0)
+	require.Equal(t, "", relativeTime(now, 0))
+	require.Equal(t, "3 minutes ago", relativeTime(now, 10000-180))
+	require.Equal(t, "in about an hour", relativeTime(now, 10000+3600))
+}
+
+func TestHandlerStatusPage(t *testing.T) {
+	te := NewTestEnv(t)
+	now := time.Now().Unix()
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{
+		{Name: "ubuntu", Size: 2048, LastSuccess: now - 180, PrevRun: now - 300, NextRun: now + 3600},
+		{Name: "debian", ExitCode: 25, LastSuccess: now - 7200, PrevRun: now - 300, Stale: true},
+		{Name: "arch", Paused: true, PausedReason: "upstream outage"},
+	}).Error)
+	cli := resty.New().SetBaseURL(te.httpSrv.URL)
+
+	resp, err := cli.R().Get("/status")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.Contains(t, resp.Header().Get("Content-Type"), "text/html")
+	body := resp.String()
+	require.Contains(t, body, `<tr class="success">`)
+	require.Contains(t, body, "3 minutes ago")
+	require.Contains(t, body, "2KiB")
+	require.Contains(t, body, `<tr class="failed">`)
+	require.Contains(t, body, "(exit code 25)")
+	require.Contains(t, body, `<span class="stale-mark">stale</span>`)
+	require.Contains(t, body, "paused</span>: upstream outage")
+
+	resp, err = cli.R().SetQueryParam("stale", "true").Get("/status")
+	require.NoError(t, err)
+	require.Contains(t, resp.String(), "debian")
+	require.NotContains(t, resp.String(), "ubuntu")
+
+	// The templates in the override directory replace the embedded blocks.
+	dir := t.TempDir()
+	testutils.WriteFile(t, filepath.Join(dir, "theme.html"), `{{define "header"}}<h1>Example Mirror</h1>{{end}}`)
+	te.server.statusTmpl, err = newStatusTemplate(dir)
+	require.NoError(t, err)
+	resp, err = cli.R().Get("/status")
+	require.NoError(t, err)
+	require.Contains(t, resp.String(), "<h1>Example Mirror</h1>")
+	require.Contains(t, resp.String(), `<tr class="failed">`)
+}
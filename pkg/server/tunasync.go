@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+const tunasyncTimeLayout = "2006-01-02 15:04:05 -0700"
+
+// The job statuses of tunasync.
+const (
+	tunasyncNone    = "none"
+	tunasyncSuccess = "success"
+	tunasyncFailed  = "failed"
+	tunasyncSyncing = "syncing"
+	tunasyncPaused  = "paused"
+)
+
+func tunasyncStatus(meta model.RepoMeta) string {
+	switch {
+	case meta.Paused:
+		return tunasyncPaused
+	case meta.Syncing:
+		return tunasyncSyncing
+	case meta.ExitCode != 0:
+		return tunasyncFailed
+	case meta.LastSuccess > 0:
+		return tunasyncSuccess
+	default:
+		return tunasyncNone
+	}
+}
+
+func tunasyncTime(ts int64) string {
+	if ts <= 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).Format(tunasyncTimeLayout)
+}
+
+// convertModelRepoMetaToTunasyncJob converts the metadata of the repo into a tunasync job.
+// lastEnded is the time when the last sync finished.
+func convertModelRepoMetaToTunasyncJob(meta model.RepoMeta, lastEnded int64) api.TunasyncJob {
+	size := "unknown"
+	if meta.Size > 0 {
+		size = units.BytesSize(float64(meta.Size))
+	}
+	return api.TunasyncJob{
+		Name:           meta.Name,
+		IsMaster:       true,
+		Status:         tunasyncStatus(meta),
+		LastUpdate:     tunasyncTime(meta.LastSuccess),
+		LastUpdateTs:   meta.LastSuccess,
+		LastStarted:    tunasyncTime(meta.PrevRun),
+		LastStartedTs:  meta.PrevRun,
+		LastEnded:      tunasyncTime(lastEnded),
+		LastEndedTs:    lastEnded,
+		NextSchedule:   tunasyncTime(meta.NextRun),
+		NextScheduleTs: meta.NextRun,
+		Upstream:       meta.Upstream,
+		Size:           size,
+	}
+}
+
+func (s *Server) handlerListTunasyncJobs(c echo.Context) error {
+	l := getLogger(c)
+	l.Debug("Invoked")
+
+	if !s.config.TunasyncJobs {
+		return newHTTPError(http.StatusNotFound, "tunasync_jobs is not enabled")
+	}
+
+	db := s.getDB(c)
+	var metas []model.RepoMeta
+	err := db.Order("name").Find(&metas).Error
+	if err != nil {
+		const msg = "Fail to list RepoMetas"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	var records []struct {
+		Name       string
+		FinishedAt int64
+	}
+	err = db.Model(&model.SyncRecord{}).
+		Select("name", "MAX(finished_at) AS finished_at").
+		Group("name").
+		Find(&records).Error
+	if err != nil {
+		const msg = "Fail to list SyncRecords"
+		l.Error(msg, slogErrAttr(err))
+		return newHTTPError(http.StatusInternalServerError, msg)
+	}
+	lastEnded := make(map[string]int64, len(records))
+	for _, r := range records {
+		lastEnded[r.Name] = r.FinishedAt
+	}
+
+	resp := make([]api.TunasyncJob, len(metas))
+	for i, meta := range metas {
+		ended, ok := lastEnded[meta.Name]
+		if !ok {
+			// The sync records may have been cleaned up.
+			ended = meta.LastSuccess
+		}
+		resp[i] = convertModelRepoMetaToTunasyncJob(meta, ended)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
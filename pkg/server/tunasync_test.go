@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/model"
+)
+
+func TestHandlerListTunasyncJobs(t *testing.T) {
+	te := NewTestEnv(t)
+	cli := te.RESTClient()
+
+	resp, err := cli.R().Get("/tunasync/jobs")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	te.server.config.TunasyncJobs = true
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{
+		{Name: "ubuntu", Upstream: "rsync://archive.ubuntu.com/ubuntu/", Size: 2048, LastSuccess: 200, PrevRun: 100, NextRun: 300},
+		{Name: "debian", ExitCode: 1, LastSuccess: 200, PrevRun: 500},
+		{Name: "centos", Syncing: true, PrevRun: 100},
+		{Name: "arch", Paused: true},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.SyncRecord{
+		{Name: "debian", StartedAt: 150, FinishedAt: 200},
+		{Name: "debian", StartedAt: 500, FinishedAt: 600, ExitCode: 1},
+	}).Error)
+
+	var jobs []api.TunasyncJob
+	resp, err = cli.R().SetResult(&jobs).Get("/tunasync/jobs")
+	require.NoError(t, err)
+	require.True(t, resp.IsSuccess(), "Unexpected response: %s", resp.Body())
+
+	format := func(ts int64) string {
+		return time.Unix(ts, 0).Format(tunasyncTimeLayout)
+	}
+	require.Equal(t, []api.TunasyncJob{
+		{
+			Name:     "arch",
+			IsMaster: true,
+			Status:   tunasyncPaused,
+			Size:     "unknown",
+		},
+		{
+			Name:          "centos",
+			IsMaster:      true,
+			Status:        tunasyncSyncing,
+			LastStarted:   format(100),
+			LastStartedTs: 100,
+			Size:          "unknown",
+		},
+		{
+			Name:          "debian",
+			IsMaster:      true,
+			Status:        tunasyncFailed,
+			LastUpdate:    format(200),
+			LastUpdateTs:  200,
+			LastStarted:   format(500),
+			LastStartedTs: 500,
+			LastEnded:     format(600),
+			LastEndedTs:   600,
+			Size:          "unknown",
+		},
+		{
+			Name:           "ubuntu",
+			IsMaster:       true,
+			Status:         tunasyncSuccess,
+			LastUpdate:     format(200),
+			LastUpdateTs:   200,
+			LastStarted:    format(100),
+			LastStartedTs:  100,
+			LastEnded:      format(200),
+			LastEndedTs:    200,
+			NextSchedule:   format(300),
+			NextScheduleTs: 300,
+			Upstream:       "rsync://archive.ubuntu.com/ubuntu/",
+			Size:           "2KiB",
+		},
+	}, jobs)
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -27,8 +29,18 @@ import (
 
 const suffixYAML = ".yaml"
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const defaultRetryBaseDelay = 5 * time.Minute
+
 var errNotFound = errors.New("not found")
 
+// errOverridden means the persisted config would be overridden by a later repo_config_dir.
+var errOverridden = errors.New("overridden by a later repo_config_dir")
+
 func (s *Server) getDB(c echo.Context) *gorm.DB {
 	return s.db.WithContext(c.Request().Context())
 }
@@ -43,15 +55,29 @@ func getRepoNameFromRoute(c echo.Context) (string, error) {
 
 func (s *Server) convertModelRepoMetaToGetMetaResponse(in model.RepoMeta) api.GetRepoMetaResponse {
 	return api.GetRepoMetaResponse{
-		Name:        in.Name,
-		Upstream:    in.Upstream,
-		Syncing:     in.Syncing,
-		Size:        in.Size,
-		ExitCode:    in.ExitCode,
-		LastSuccess: in.LastSuccess,
-		UpdatedAt:   in.UpdatedAt,
-		PrevRun:     in.PrevRun,
-		NextRun:     in.NextRun,
+		Name:         in.Name,
+		Upstream:     in.Upstream,
+		Syncing:      in.Syncing,
+		Size:         in.Size,
+		ExitCode:     in.ExitCode,
+		LastSuccess:  in.LastSuccess,
+		UpdatedAt:    in.UpdatedAt,
+		PrevRun:      in.PrevRun,
+		NextRun:      in.NextRun,
+		Paused:       in.Paused,
+		PausedReason: in.PausedReason,
+		PausedUntil:  in.PausedUntil,
+		Stale:        in.Stale,
+	}
+}
+
+// streamContext returns the context of a long-running response, which is also cancelled when the server shuts down.
+func (s *Server) streamContext(c echo.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	stop := context.AfterFunc(s.streamCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
 	}
 }
 
@@ -66,25 +92,41 @@ func newHTTPError(code int, msg string) error {
 	}
 }
 
-func (s *Server) waitForSync(name, ctID, storageDir, envUpstream string) {
+// syncTimeout returns the timeout of the repo, which falls back to sync_timeout.
+func (s *Server) syncTimeout(repo *model.Repo) time.Duration {
+	if repo.Timeout > 0 {
+		return time.Duration(repo.Timeout)
+	}
+	return s.config.SyncTimeout
+}
+
+func (s *Server) waitForSync(name, ctID, storageDir, envUpstream string, timeout time.Duration) {
 	l := s.logger.With(slog.String("repo", name))
-	code, err := s.dockerCli.WaitContainerWithTimeout(ctID, s.config.SyncTimeout)
+	defer s.queue.release(name)
+	code, err := s.dockerCli.WaitContainerWithTimeout(ctID, timeout)
+	_, cancelled := s.cancelledSyncs.Pop(ctID)
 	if err != nil {
 		if !errors.Is(err, context.DeadlineExceeded) {
 			l.Error("Fail to wait for container", slogErrAttr(err))
 			return
 		} else {
 			// Here we set a special exit code to indicate that the container is timeout in meta.
-			code = -2
+			code = api.ExitCodeTimeout
 		}
 	}
+	if cancelled {
+		l.Info("Sync cancelled")
+		code = api.ExitCodeCancelled
+	}
+	finishedAt := time.Now()
 	err = s.dockerCli.RemoveContainerWithTimeout(ctID, time.Second*20)
 	if err != nil {
 		l.Error("Fail to remove container", slogErrAttr(err))
 	}
 
+	size := s.getSize(storageDir)
 	updates := map[string]any{
-		"size":      s.getSize(storageDir),
+		"size":      size,
 		"exit_code": code,
 		"syncing":   false,
 	}
@@ -100,7 +142,13 @@ func (s *Server) waitForSync(name, ctID, storageDir, envUpstream string) {
 		updates["upstream"] = upstream
 	}
 	if code == 0 {
-		updates["last_success"] = time.Now().Unix()
+		updates["last_success"] = finishedAt.Unix()
+		updates["retry_attempt"] = 0
+	} else if !cancelled {
+		err = s.applyRetryPolicy(name, code, finishedAt, updates)
+		if err != nil {
+			l.Error("Fail to apply retry policy", slogErrAttr(err))
+		}
 	}
 
 	err = s.db.
@@ -111,7 +159,32 @@ func (s *Server) waitForSync(name, ctID, storageDir, envUpstream string) {
 		l.Error("Fail to update RepoMeta", slogErrAttr(err))
 	}
 
-	if len(s.config.PostSync) == 0 {
+	record, err := s.finishSyncRecord(name, model.SyncRecord{
+		FinishedAt: finishedAt.Unix(),
+		ExitCode:   code,
+		TimedOut:   code == api.ExitCodeTimeout,
+		Cancelled:  cancelled,
+		SizeAfter:  size,
+		Upstream:   upstream,
+	})
+	if err != nil {
+		l.Error("Fail to save SyncRecord", slogErrAttr(err))
+	}
+	s.metrics.observeSync(record)
+	s.notify(record)
+	s.finishRollout(record)
+	event := api.Event{
+		Type:     api.EventSyncFinished,
+		Time:     finishedAt.Unix(),
+		Repo:     name,
+		ExitCode: &code,
+	}
+	if code == api.ExitCodeTimeout {
+		event.Type = api.EventSyncTimeout
+	}
+	s.events.publish(event)
+
+	if len(s.config.PostSync) == 0 || cancelled {
 		return
 	}
 	go func() {
@@ -133,8 +206,125 @@ func (s *Server) waitForSync(name, ctID, storageDir, envUpstream string) {
 	}()
 }
 
+// retryDelay returns the delay before the given retry attempt, which starts from 0.
+func retryDelay(policy *model.RetryPolicy, attempt int) time.Duration {
+	delay := time.Duration(policy.BaseDelay)
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	maxDelay := time.Duration(policy.MaxDelay)
+	for i := 0; i < attempt; i++ {
+		if maxDelay > 0 && delay >= maxDelay {
+			break
+		}
+		if delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func isRetryable(policy *model.RetryPolicy, code int) bool {
+	if code == api.ExitCodeTimeout {
+		return policy.RetryOnTimeout
+	}
+	if len(policy.ExitCodes) == 0 {
+		return true
+	}
+	return slices.Contains(policy.ExitCodes, code)
+}
+
+// applyRetryPolicy brings next_run forward according to the retry policy of the repo
+// if the failed sync is retryable. The changes are added to updates.
+func (s *Server) applyRetryPolicy(name string, code int, finishedAt time.Time, updates map[string]any) error {
+	var repo model.Repo
+	res := s.db.Select("retry_policy").Where(model.Repo{Name: name}).Limit(1).Find(&repo)
+	if res.Error != nil {
+		return fmt.Errorf("get repo: %w", res.Error)
+	}
+	policy := repo.RetryPolicy
+	if res.RowsAffected == 0 || policy == nil || policy.MaxAttempts <= 0 || !isRetryable(policy, code) {
+		updates["retry_attempt"] = 0
+		return nil
+	}
+
+	var meta model.RepoMeta
+	err := s.db.Select("next_run", "retry_attempt").Where(model.RepoMeta{Name: name}).Limit(1).Find(&meta).Error
+	if err != nil {
+		return fmt.Errorf("get meta: %w", err)
+	}
+	if meta.RetryAttempt >= policy.MaxAttempts {
+		// Give up and wait for the next scheduled sync.
+		s.logger.Warn("Retry attempts exhausted", slog.String("repo", name), slog.Int("attempts", meta.RetryAttempt))
+		updates["retry_attempt"] = 0
+		return nil
+	}
+
+	nextRun := finishedAt.Add(retryDelay(policy, meta.RetryAttempt)).Unix()
+	updates["retry_attempt"] = meta.RetryAttempt + 1
+	if meta.NextRun <= 0 || nextRun < meta.NextRun {
+		updates["next_run"] = nextRun
+	}
+	s.logger.Info("Retry scheduled",
+		slog.String("repo", name),
+		slog.Int("attempt", meta.RetryAttempt+1),
+		slog.Time("next_run", time.Unix(nextRun, 0)),
+	)
+	return nil
+}
+
+// scheduledNextRun returns the assignment of next_run for upserting RepoMeta.
+// It keeps next_run if a retry is pending, so that the retry is not dropped when the repo is reloaded.
+func scheduledNextRun(nextRun int64, now time.Time) clause.Expr {
+	return gorm.Expr("CASE WHEN retry_attempt > 0 AND next_run > ? THEN next_run ELSE ? END", now.Unix(), nextRun)
+}
+
+// finishSyncRecord fills the result of the latest unfinished SyncRecord of the given repo.
+// A new record will be created if there is none, e.g. the container was started before yukid restarted.
+func (s *Server) finishSyncRecord(name string, result model.SyncRecord) (model.SyncRecord, error) {
+	result.Name = name
+	var record model.SyncRecord
+	res := s.db.
+		Where("name = ? AND finished_at = 0", name).
+		Order("id DESC").
+		Limit(1).
+		Find(&record)
+	if res.Error != nil {
+		return result, res.Error
+	}
+	if res.RowsAffected == 0 {
+		var meta model.RepoMeta
+		err := s.db.Select("prev_run").Where(model.RepoMeta{Name: name}).Limit(1).Find(&meta).Error
+		if err != nil {
+			return result, err
+		}
+		record = model.SyncRecord{
+			Name:       name,
+			StartedAt:  meta.PrevRun,
+			SizeBefore: -1,
+			Trigger:    model.SyncTriggerUnknown,
+		}
+	}
+	record.FinishedAt = result.FinishedAt
+	record.ExitCode = result.ExitCode
+	record.TimedOut = result.TimedOut
+	record.Cancelled = result.Cancelled
+	record.SizeAfter = result.SizeAfter
+	record.Upstream = result.Upstream
+	return record, s.db.Save(&record).Error
+}
+
 func (s *Server) readUpstreamFromLog(name string) (string, error) {
-	content, err := os.ReadFile(filepath.Join(s.config.RepoLogsDir, name, "yuki_upstream.txt"))
+	f, _, err := openLogFile(filepath.Join(s.config.RepoLogsDir, name, "yuki_upstream.txt"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
 	if err != nil {
 		return "", err
 	}
@@ -176,16 +366,22 @@ func (s *Server) waitRunningContainers() error {
 		// logger.Error("Fail to list containers", slogErrAttr(err))
 		return fmt.Errorf("list containers: %w", err)
 	}
+	running := make([]string, 0, len(cts))
 	for _, ct := range cts {
 		name := ct.Labels[api.LabelRepoName]
 		dir := ct.Labels[api.LabelStorageDir]
 		ctID := ct.ID
 
 		envUpstream := ""
+		group := ""
+		// The timeout restarts since the elapsed time of the container is unknown.
+		timeout := s.config.SyncTimeout
 		if len(name) > 0 {
 			var repo model.Repo
 			if err := s.db.Where(model.Repo{Name: name}).Limit(1).Take(&repo).Error; err == nil {
 				envUpstream = getEnvUpstream(repo.Envs)
+				group = repo.ConcurrencyGroup
+				timeout = s.syncTimeout(&repo)
 			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 				s.logger.Warn("Fail to load repo for upstream lookup", slogErrAttr(err), slog.String("repo", name))
 			}
@@ -198,28 +394,31 @@ func (s *Server) waitRunningContainers() error {
 		if err != nil {
 			s.logger.Error("Fail to set syncing to true", slogErrAttr(err), slog.String("repo", name))
 		}
-		go s.waitForSync(name, ctID, dir, envUpstream)
+		s.queue.markRunning(name, group)
+		running = append(running, name)
+		go s.waitForSync(name, ctID, dir, envUpstream, timeout)
 	}
-	return nil
+	return s.closeLostSyncRecords(running)
 }
 
-func (s *Server) upgradeImages() {
-	db := s.db
-	logger := s.logger
-	logger.Debug("Upgrading images")
-
-	var images []string
-	err := db.Model(&model.Repo{}).
-		Distinct("image").
-		Pluck("image", &images).Error
-	if err != nil {
-		logger.Error("Fail to query images", slogErrAttr(err))
-		return
+// closeLostSyncRecords finishes the unfinished SyncRecords whose containers are no longer running,
+// so that they are not shown as running forever.
+func (s *Server) closeLostSyncRecords(running []string) error {
+	query := s.db.Model(&model.SyncRecord{}).Where("finished_at = 0")
+	if len(running) > 0 {
+		query = query.Where("name NOT IN ?", running)
+	}
+	res := query.Updates(map[string]any{
+		"finished_at": time.Now().Unix(),
+		"exit_code":   api.ExitCodeLost,
+	})
+	if res.Error != nil {
+		return fmt.Errorf("close lost sync records: %w", res.Error)
 	}
-	err = s.dockerCli.UpgradeImages(images)
-	if err != nil {
-		logger.Error("Fail to upgrade images", slogErrAttr(err))
+	if res.RowsAffected > 0 {
+		s.logger.Warn("Closed sync records without running containers", slog.Int64("count", res.RowsAffected))
 	}
+	return nil
 }
 
 func (s *Server) scheduleTasks(ctx context.Context) {
@@ -228,13 +427,12 @@ func (s *Server) scheduleTasks(ctx context.Context) {
 		ticker := time.NewTicker(time.Second * 10)
 		defer ticker.Stop()
 		for {
-			var metas []model.RepoMeta
-			s.db.Select("name").Where("next_run <= ?", time.Now().Unix()).Find(&metas)
-			for _, meta := range metas {
-				name := meta.Name
-				l := s.logger.With(slog.String("repo", name))
-				err := s.syncRepo(context.Background(), name, false)
+			s.enqueueDueRepos()
+			for _, item := range s.queue.pop() {
+				l := s.logger.With(slog.String("repo", item.name))
+				err := s.syncRepo(context.Background(), item.name, false, model.SyncTriggerSchedule)
 				if err != nil {
+					s.queue.release(item.name)
 					if errdefs.IsConflict(err) {
 						l.Warn("Still syncing")
 					} else {
@@ -246,6 +444,21 @@ func (s *Server) scheduleTasks(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
+			case <-s.queue.notify:
+			}
+		}
+	}()
+
+	// check staleness
+	go func() {
+		ticker := time.NewTicker(staleCheckInterval)
+		defer ticker.Stop()
+		for {
+			s.checkStaleness(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 			}
 		}
 	}()
@@ -267,6 +480,52 @@ func (s *Server) scheduleTasks(ctx context.Context) {
 	}
 }
 
+// enqueueDueRepos pushes the repos whose next_run has passed into the sync queue.
+func (s *Server) enqueueDueRepos() {
+	now := time.Now().Unix()
+	err := s.db.Model(&model.RepoMeta{}).
+		Where("paused = ? AND paused_until > 0 AND paused_until <= ?", true, now).
+		Updates(map[string]any{
+			"paused":        false,
+			"paused_reason": "",
+			"paused_until":  0,
+		}).Error
+	if err != nil {
+		s.logger.Error("Fail to resume expired paused repos", slogErrAttr(err))
+	}
+
+	var names []string
+	err = s.db.Model(&model.RepoMeta{}).
+		Where("next_run <= ? AND paused = ?", now, false).
+		Pluck("name", &names).Error
+	if err != nil {
+		s.logger.Error("Fail to list due repos", slogErrAttr(err))
+		return
+	}
+	if len(names) == 0 {
+		return
+	}
+	var repos []model.Repo
+	err = s.db.Select("name", "concurrency_group", "priority").
+		Where("name IN ?", names).
+		Find(&repos).Error
+	if err != nil {
+		s.logger.Error("Fail to list due repos", slogErrAttr(err))
+		return
+	}
+	for _, repo := range repos {
+		if s.queue.isRunning(repo.Name) {
+			// Skip this round like what cron does.
+			s.logger.Warn("Still syncing", slog.String("repo", repo.Name))
+			s.updateNextRun(s.db, repo.Name, time.Now())
+			continue
+		}
+		if s.queue.push(repo.Name, repo.ConcurrencyGroup, repo.Priority) {
+			s.logger.Debug("Queued", slog.String("repo", repo.Name))
+		}
+	}
+}
+
 func (s *Server) initRepoMetas() error {
 	db := s.db
 	var repos []model.Repo
@@ -275,19 +534,20 @@ func (s *Server) initRepoMetas() error {
 			for _, repo := range repos {
 				schedule, _ := cron.ParseStandard(repo.Cron)
 				s.repoSchedules.Set(repo.Name, schedule)
-				nextRun := schedule.Next(time.Now()).Unix()
+				now := time.Now()
+				nextRun := schedule.Next(now).Unix()
 				size := s.getSize(repo.StorageDir)
 				err := db.Clauses(clause.OnConflict{
 					DoUpdates: clause.Assignments(map[string]any{
 						"size":     size,
 						"syncing":  false,
-						"next_run": nextRun,
+						"next_run": scheduledNextRun(nextRun, now),
 					}),
 				}).Create(&model.RepoMeta{
 					Name:     repo.Name,
 					Size:     size,
 					NextRun:  nextRun,
-					ExitCode: -1,
+					ExitCode: api.ExitCodeNeverSynced,
 				}).Error
 				if err != nil {
 					return fmt.Errorf("init meta for repo %q: %w", repo.Name, err)
@@ -297,22 +557,11 @@ func (s *Server) initRepoMetas() error {
 		}).Error
 }
 
-func (s *Server) syncRepo(ctx context.Context, name string, debug bool) error {
-	db := s.db.WithContext(ctx)
-	var repo model.Repo
-	res := db.Where(model.Repo{Name: name}).Limit(1).Find(&repo)
-	if res.Error != nil {
-		return fmt.Errorf("get repo %q: %w", name, res.Error)
-	}
-	if res.RowsAffected == 0 {
-		return fmt.Errorf("get repo %q: %w", name, errNotFound)
-	}
-
-	// Update next_run unconditionally
+// updateNextRun sets next_run of the given repo to the next scheduled time after now.
+func (s *Server) updateNextRun(db *gorm.DB, name string, now time.Time) int64 {
 	logger := s.logger.With(slog.String("repo", name))
-	now := time.Now()
 	var nextRun int64
-	schedule, ok := s.repoSchedules.Get(repo.Name)
+	schedule, ok := s.repoSchedules.Get(name)
 	if ok {
 		nextRun = schedule.Next(now).Unix()
 	} else {
@@ -325,6 +574,68 @@ func (s *Server) syncRepo(ctx context.Context, name string, debug bool) error {
 	if err != nil {
 		logger.Error("Fail to update next_run", slogErrAttr(err))
 	}
+	return nextRun
+}
+
+// cancelSync stops the running sync container of the given repo, or removes the repo from the sync queue.
+// It returns errNotFound if the repo is neither syncing nor queued.
+func (s *Server) cancelSync(name string) error {
+	if s.queue.remove(name) {
+		// Otherwise the repo will be queued again since its next_run has passed.
+		s.updateNextRun(s.db, name, time.Now())
+		return nil
+	}
+	cts, err := s.dockerCli.ListContainersWithTimeout(true, time.Second*10)
+	if err != nil {
+		return fmt.Errorf("list containers: %w", err)
+	}
+	for _, ct := range cts {
+		if ct.Labels[api.LabelRepoName] != name {
+			continue
+		}
+		// The flag is keyed by the container ID so that it never applies to a later sync,
+		// in case the container has exited and been waited before it is stopped here.
+		s.cancelledSyncs.Set(ct.ID, struct{}{})
+		err = s.dockerCli.StopContainerWithTimeout(ct.ID, time.Second*30)
+		if err != nil {
+			s.cancelledSyncs.Remove(ct.ID)
+			return fmt.Errorf("stop container %q: %w", ct.ID, err)
+		}
+		return nil
+	}
+	return errNotFound
+}
+
+func (s *Server) syncRepo(ctx context.Context, name string, debug bool, trigger string) error {
+	db := s.db.WithContext(ctx)
+	var repo model.Repo
+	res := db.Where(model.Repo{Name: name}).Limit(1).Find(&repo)
+	if res.Error != nil {
+		return fmt.Errorf("get repo %q: %w", name, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("get repo %q: %w", name, errNotFound)
+	}
+
+	logger := s.logger.With(slog.String("repo", name))
+	if trigger == model.SyncTriggerSchedule {
+		var meta model.RepoMeta
+		err := db.Select("retry_attempt").Where(model.RepoMeta{Name: name}).Limit(1).Find(&meta).Error
+		if err != nil {
+			logger.Error("Fail to get RepoMeta", slogErrAttr(err))
+		} else if meta.RetryAttempt > 0 {
+			trigger = model.SyncTriggerRetry
+		}
+	}
+
+	// Update next_run unconditionally
+	now := time.Now()
+	nextRun := s.updateNextRun(db, name, now)
+	s.events.publish(api.Event{
+		Type:    api.EventSyncScheduled,
+		Repo:    name,
+		NextRun: nextRun,
+	})
 
 	if len(repo.BindIP) == 0 {
 		repo.BindIP = s.config.BindIP
@@ -366,24 +677,42 @@ func (s *Server) syncRepo(ctx context.Context, name string, debug bool) error {
 		binds = append(binds, k+":"+v)
 	}
 	ctName := s.config.NamePrefix + name
+	sizeBefore := s.getSize(repo.StorageDir)
 
-	ctID, err := s.dockerCli.RunContainer(
-		ctx,
-		docker.RunContainerConfig{
-			Labels: map[string]string{
-				api.LabelRepoName:   repo.Name,
-				api.LabelStorageDir: repo.StorageDir,
-			},
-			Env:     envs,
-			Image:   repo.Image,
-			Name:    ctName,
-			Binds:   binds,
-			Network: repo.Network,
+	img, err := s.resolveImage(db, &repo)
+	if err != nil {
+		return fmt.Errorf("resolve image: %w", err)
+	}
+	ctConfig := docker.RunContainerConfig{
+		Labels: map[string]string{
+			api.LabelRepoName:   repo.Name,
+			api.LabelStorageDir: repo.StorageDir,
 		},
-	)
+		Env:        envs,
+		Image:      img,
+		PullPolicy: repo.PullPolicy,
+		Name:       ctName,
+		Binds:      binds,
+		Network:    repo.Network,
+
+		CPUShares:   repo.CPUShares,
+		Memory:      int64(repo.Memory),
+		PidsLimit:   repo.PidsLimit,
+		BlkioWeight: repo.BlkioWeight,
+	}
+	err = applySecurityOptions(&ctConfig, s.securityOptions(&repo))
+	if err != nil {
+		return err
+	}
+	ctID, err := s.dockerCli.RunContainer(ctx, ctConfig)
 	if err != nil {
 		return fmt.Errorf("run container: %w", err)
 	}
+	s.queue.markRunning(name, repo.ConcurrencyGroup)
+	s.events.publish(api.Event{
+		Type: api.EventContainerStarted,
+		Repo: name,
+	})
 
 	err = db.
 		Where(model.RepoMeta{Name: name}).
@@ -394,7 +723,19 @@ func (s *Server) syncRepo(ctx context.Context, name string, debug bool) error {
 	if err != nil {
 		logger.Error("Fail to update RepoMeta", slogErrAttr(err))
 	}
-	go s.waitForSync(name, ctID, repo.StorageDir, envUpstream)
+	err = db.Create(&model.SyncRecord{
+		Name:        name,
+		StartedAt:   now.Unix(),
+		SizeBefore:  sizeBefore,
+		Debug:       debug,
+		Trigger:     trigger,
+		Image:       img,
+		ImageDigest: s.imageDigest(logger, img),
+	}).Error
+	if err != nil {
+		logger.Error("Fail to create SyncRecord", slogErrAttr(err))
+	}
+	go s.waitForSync(name, ctID, repo.StorageDir, envUpstream, s.syncTimeout(&repo))
 
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/ustclug/Yuki/pkg/api"
 	"github.com/ustclug/Yuki/pkg/docker"
+	fakedocker "github.com/ustclug/Yuki/pkg/docker/fake"
 	"github.com/ustclug/Yuki/pkg/model"
 	testutils "github.com/ustclug/Yuki/test/utils"
 )
@@ -27,33 +28,50 @@ func TestInitRepoMetas(t *testing.T) {
 			Name: "repo1",
 			Cron: "@every 1h",
 		},
+		{
+			Name: "repo2",
+			Cron: "@every 1h",
+		},
 	}).Error)
+	retryAt := time.Now().Add(time.Minute).Unix()
 	require.NoError(t, te.server.db.Create([]model.RepoMeta{
 		{
 			Name:     "repo0",
 			Size:     100,
 			ExitCode: 0,
 		},
+		{
+			Name:         "repo2",
+			ExitCode:     1,
+			RetryAttempt: 1,
+			NextRun:      retryAt,
+		},
 	}).Error)
 
 	require.NoError(t, te.server.initRepoMetas())
 
 	var metas []model.RepoMeta
 	require.NoError(t, te.server.db.Order("name").Find(&metas).Error)
-	require.Len(t, metas, 2)
+	require.Len(t, metas, 3)
 	require.Equal(t, int64(-1), metas[0].Size)
 	require.Equal(t, 0, metas[0].ExitCode)
+	require.Greater(t, metas[0].NextRun, retryAt)
 
 	require.Equal(t, int64(-1), metas[1].Size)
 	require.Equal(t, -1, metas[1].ExitCode)
+
+	// The pending retry is kept.
+	require.Equal(t, retryAt, metas[2].NextRun)
 }
 
 type fakeImageClient struct {
 	docker.Client
 	pullImage func(ctx context.Context, image string) error
+	// removeDanglingImages is optional.
+	removeDanglingImages func(keep []string) error
 }
 
-func (f *fakeImageClient) UpgradeImages(refs []string) error {
+func (f *fakeImageClient) PullImages(refs []string) error {
 	for _, ref := range refs {
 		err := f.pullImage(context.Background(), ref)
 		if err != nil {
@@ -63,6 +81,13 @@ func (f *fakeImageClient) UpgradeImages(refs []string) error {
 	return nil
 }
 
+func (f *fakeImageClient) RemoveDanglingImages(keep []string) error {
+	if f.removeDanglingImages == nil {
+		return nil
+	}
+	return f.removeDanglingImages(keep)
+}
+
 func TestUpgradeImages(t *testing.T) {
 	te := NewTestEnv(t)
 	var (
@@ -117,6 +142,11 @@ func TestWaitRunningContainers(t *testing.T) {
 		},
 	)
 	require.NoError(t, err)
+	// The container of repo1 disappeared while yukid was not running.
+	require.NoError(t, te.server.db.Create([]model.SyncRecord{
+		{Name: "repo0", StartedAt: 100},
+		{Name: "repo1", StartedAt: 100},
+	}).Error)
 	require.NoError(t, te.server.waitRunningContainers())
 
 	meta := model.RepoMeta{
@@ -125,12 +155,156 @@ func TestWaitRunningContainers(t *testing.T) {
 	require.NoError(t, te.server.db.First(&meta).Error)
 	require.True(t, meta.Syncing)
 
+	var running, lost model.SyncRecord
+	require.NoError(t, te.server.db.Where("name = ?", "repo0").First(&running).Error)
+	require.Zero(t, running.FinishedAt)
+	require.NoError(t, te.server.db.Where("name = ?", "repo1").First(&lost).Error)
+	require.NotZero(t, lost.FinishedAt)
+	require.Equal(t, api.ExitCodeLost, lost.ExitCode)
+
 	testutils.PollUntilTimeout(t, time.Minute, func() bool {
 		require.NoError(t, te.server.db.First(&meta).Error)
 		return !meta.Syncing
 	})
 }
 
+type recordingClient struct {
+	docker.Client
+	mu       sync.Mutex
+	timeouts []time.Duration
+}
+
+func (r *recordingClient) WaitContainerWithTimeout(id string, timeout time.Duration) (int, error) {
+	r.mu.Lock()
+	r.timeouts = append(r.timeouts, timeout)
+	r.mu.Unlock()
+	return r.Client.WaitContainerWithTimeout(id, timeout)
+}
+
+func TestSyncRepoResources(t *testing.T) {
+	te := NewTestEnv(t)
+	te.server.config.RepoLogsDir = t.TempDir()
+	te.server.config.SyncTimeout = time.Hour
+	fakeCli := te.server.dockerCli.(*fakedocker.Client)
+	dockerCli := &recordingClient{Client: fakeCli}
+	te.server.dockerCli = dockerCli
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{
+			Name:        "repo0",
+			Image:       "alpine:latest",
+			StorageDir:  t.TempDir(),
+			Timeout:     model.Duration(time.Millisecond * 100),
+			CPUShares:   512,
+			Memory:      1 << 30,
+			PidsLimit:   100,
+			BlkioWeight: 200,
+		},
+		{
+			Name:       "repo1",
+			Image:      "alpine:latest",
+			StorageDir: t.TempDir(),
+		},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{{Name: "repo0"}, {Name: "repo1"}}).Error)
+
+	require.NoError(t, te.server.syncRepo(context.TODO(), "repo0", false, model.SyncTriggerManual))
+	runs := fakeCli.Runs()
+	require.Len(t, runs, 1)
+	hostConfig := runs[0].HostConfig
+	require.EqualValues(t, 512, hostConfig.CPUShares)
+	require.EqualValues(t, 1<<30, hostConfig.Memory)
+	require.NotNil(t, hostConfig.PidsLimit)
+	require.EqualValues(t, 100, *hostConfig.PidsLimit)
+	require.EqualValues(t, 200, hostConfig.BlkioWeight)
+
+	require.NoError(t, te.server.syncRepo(context.TODO(), "repo1", false, model.SyncTriggerManual))
+	testutils.PollUntilTimeout(t, time.Minute, func() bool {
+		dockerCli.mu.Lock()
+		defer dockerCli.mu.Unlock()
+		return len(dockerCli.timeouts) == 2
+	})
+	require.ElementsMatch(t, []time.Duration{time.Millisecond * 100, time.Hour}, dockerCli.timeouts)
+
+	// repo0 exceeds its own timeout while repo1 does not.
+	var meta model.RepoMeta
+	testutils.PollUntilTimeout(t, time.Minute, func() bool {
+		require.NoError(t, te.server.db.Where(model.RepoMeta{Name: "repo0"}).First(&meta).Error)
+		return !meta.Syncing
+	})
+	require.Equal(t, api.ExitCodeTimeout, meta.ExitCode)
+}
+
+func TestSyncRepoSecurity(t *testing.T) {
+	te := NewTestEnv(t)
+	te.server.config.RepoLogsDir = t.TempDir()
+	te.server.config.ContainerSecurity = SecurityConfig{
+		ReadOnlyRootfs:  true,
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		AppArmorProfile: "yuki-sync",
+		Tmpfs:           []string{"/run:size=16m"},
+	}
+	seccompPath := filepath.Join(t.TempDir(), "seccomp.json")
+	testutils.WriteFile(t, seccompPath, `{"defaultAction":"SCMP_ACT_ERRNO"}`)
+	fakeCli := te.server.dockerCli.(*fakedocker.Client)
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{
+			Name:       "repo0",
+			Image:      "alpine:latest",
+			StorageDir: t.TempDir(),
+		},
+		{
+			Name:       "repo1",
+			Image:      "alpine:latest",
+			StorageDir: t.TempDir(),
+			Security: &model.SecurityOptions{
+				ReadOnlyRootfs: new(bool),
+				CapAdd:         []string{"CHOWN"},
+				SeccompProfile: seccompPath,
+				Tmpfs:          []string{"/tmp:size=1g"},
+			},
+		},
+		{
+			Name:       "repo2",
+			Image:      "alpine:latest",
+			StorageDir: t.TempDir(),
+			Security: &model.SecurityOptions{
+				SeccompProfile: filepath.Join(t.TempDir(), "missing.json"),
+			},
+		},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{{Name: "repo0"}, {Name: "repo1"}, {Name: "repo2"}}).Error)
+
+	require.NoError(t, te.server.syncRepo(context.TODO(), "repo0", false, model.SyncTriggerManual))
+	require.NoError(t, te.server.syncRepo(context.TODO(), "repo1", false, model.SyncTriggerManual))
+	require.ErrorContains(t, te.server.syncRepo(context.TODO(), "repo2", false, model.SyncTriggerManual), "seccomp")
+
+	runs := fakeCli.Runs()
+	require.Len(t, runs, 2)
+
+	hostConfig := runs[0].HostConfig
+	require.True(t, hostConfig.ReadonlyRootfs)
+	require.Equal(t, []string{"ALL"}, hostConfig.CapDrop)
+	require.Empty(t, hostConfig.CapAdd)
+	require.Equal(t, []string{"no-new-privileges:true", "apparmor=yuki-sync"}, hostConfig.SecurityOpt)
+	require.Equal(t, map[string]string{"/run": "size=16m"}, hostConfig.Tmpfs)
+	require.Len(t, hostConfig.Mounts, 1)
+	require.Equal(t, "/tmp", hostConfig.Mounts[0].Target)
+
+	hostConfig = runs[1].HostConfig
+	require.False(t, hostConfig.ReadonlyRootfs)
+	require.Equal(t, []string{"ALL"}, hostConfig.CapDrop)
+	require.Equal(t, []string{"CHOWN"}, hostConfig.CapAdd)
+	require.Equal(t, []string{
+		"no-new-privileges:true",
+		`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`,
+		"apparmor=yuki-sync",
+	}, hostConfig.SecurityOpt)
+	require.Equal(t, map[string]string{"/run": "size=16m", "/tmp": "size=1g"}, hostConfig.Tmpfs)
+	// The default /tmp mount is replaced by the tmpfs of the repo.
+	require.Empty(t, hostConfig.Mounts)
+}
+
 func TestWaitForSync(t *testing.T) {
 	const name = "repo0"
 	t.Run("last_success should be updated", func(t *testing.T) {
@@ -148,7 +322,7 @@ func TestWaitForSync(t *testing.T) {
 			Name: name,
 		})
 		require.NoError(t, err)
-		te.server.waitForSync(name, id, "", "")
+		te.server.waitForSync(name, id, "", "", 0)
 
 		meta := model.RepoMeta{Name: name}
 		require.NoError(t, te.server.db.Take(&meta).Error)
@@ -171,12 +345,11 @@ func TestWaitForSync(t *testing.T) {
 			},
 		}).Error)
 
-		te.server.config.SyncTimeout = time.Second
 		id, err := te.server.dockerCli.RunContainer(context.TODO(), docker.RunContainerConfig{
 			Name: name,
 		})
 		require.NoError(t, err)
-		te.server.waitForSync(name, id, "", "")
+		te.server.waitForSync(name, id, "", "", time.Second)
 
 		meta := model.RepoMeta{Name: name}
 		require.NoError(t, te.server.db.Take(&meta).Error)
@@ -185,6 +358,53 @@ func TestWaitForSync(t *testing.T) {
 		require.False(t, meta.Syncing)
 		require.Equal(t, -2, meta.ExitCode)
 		require.Equal(t, lastSuccess, meta.LastSuccess)
+
+		var record model.SyncRecord
+		require.NoError(t, te.server.db.Where(model.SyncRecord{Name: name}).Take(&record).Error)
+		require.True(t, record.TimedOut)
+		require.Equal(t, -2, record.ExitCode)
+		require.Equal(t, model.SyncTriggerUnknown, record.Trigger)
+	})
+
+	t.Run("timeout sync should be retried", func(t *testing.T) {
+		te := NewTestEnv(t)
+		nextRun := time.Now().Add(time.Hour * 24).Unix()
+		require.NoError(t, te.server.db.Create(&model.Repo{
+			Name: name,
+			RetryPolicy: &model.RetryPolicy{
+				MaxAttempts:    2,
+				BaseDelay:      model.Duration(time.Minute),
+				RetryOnTimeout: true,
+			},
+		}).Error)
+		require.NoError(t, te.server.db.Create(&model.RepoMeta{
+			Name:         name,
+			NextRun:      nextRun,
+			RetryAttempt: 1,
+		}).Error)
+
+		id, err := te.server.dockerCli.RunContainer(context.TODO(), docker.RunContainerConfig{
+			Name: name,
+		})
+		require.NoError(t, err)
+		te.server.waitForSync(name, id, "", "", time.Second)
+
+		meta := model.RepoMeta{Name: name}
+		require.NoError(t, te.server.db.Take(&meta).Error)
+		require.Equal(t, 2, meta.RetryAttempt)
+		// The second retry should be delayed for 2 minutes.
+		require.InDelta(t, time.Now().Add(2*time.Minute).Unix(), meta.NextRun, 5)
+
+		// Retry attempts are exhausted.
+		id, err = te.server.dockerCli.RunContainer(context.TODO(), docker.RunContainerConfig{
+			Name: name,
+		})
+		require.NoError(t, err)
+		te.server.waitForSync(name, id, "", "", 0)
+		prevNextRun := meta.NextRun
+		require.NoError(t, te.server.db.Take(&meta).Error)
+		require.Empty(t, meta.RetryAttempt)
+		require.Equal(t, prevNextRun, meta.NextRun)
 	})
 
 	t.Run("upstream should be updated from log file", func(t *testing.T) {
@@ -206,7 +426,7 @@ func TestWaitForSync(t *testing.T) {
 			Name: name,
 		})
 		require.NoError(t, err)
-		te.server.waitForSync(name, id, "", "")
+		te.server.waitForSync(name, id, "", "", 0)
 
 		meta := model.RepoMeta{Name: name}
 		require.NoError(t, te.server.db.Take(&meta).Error)
@@ -229,10 +449,67 @@ func TestWaitForSync(t *testing.T) {
 			Name: name,
 		})
 		require.NoError(t, err)
-		te.server.waitForSync(name, id, "", "https://env.example.com")
+		te.server.waitForSync(name, id, "", "https://env.example.com", 0)
 
 		meta := model.RepoMeta{Name: name}
 		require.NoError(t, te.server.db.Take(&meta).Error)
 		require.Equal(t, "https://env.example.com", meta.Upstream)
 	})
 }
+
+func TestEnqueueDueRepos(t *testing.T) {
+	te := NewTestEnv(t)
+	past := time.Now().Add(-time.Minute).Unix()
+	require.NoError(t, te.server.db.Create([]model.Repo{
+		{Name: "due"},
+		{Name: "paused"},
+		{Name: "expired"},
+		{Name: "future"},
+	}).Error)
+	require.NoError(t, te.server.db.Create([]model.RepoMeta{
+		{Name: "due", NextRun: past},
+		{Name: "paused", NextRun: past, Paused: true},
+		{Name: "expired", NextRun: past, Paused: true, PausedReason: "outage", PausedUntil: past},
+		{Name: "future", NextRun: time.Now().Add(time.Hour).Unix()},
+	}).Error)
+
+	te.server.enqueueDueRepos()
+
+	var names []string
+	for _, item := range te.server.queue.pop() {
+		names = append(names, item.name)
+	}
+	require.ElementsMatch(t, []string{"due", "expired"}, names)
+
+	meta := model.RepoMeta{Name: "expired"}
+	require.NoError(t, te.server.db.Take(&meta).Error)
+	require.False(t, meta.Paused)
+	require.Empty(t, meta.PausedReason)
+	require.Empty(t, meta.PausedUntil)
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := &model.RetryPolicy{
+		BaseDelay: model.Duration(time.Minute),
+		MaxDelay:  model.Duration(5 * time.Minute),
+	}
+	require.Equal(t, time.Minute, retryDelay(policy, 0))
+	require.Equal(t, 4*time.Minute, retryDelay(policy, 2))
+	require.Equal(t, 5*time.Minute, retryDelay(policy, 3))
+	require.Equal(t, 5*time.Minute, retryDelay(policy, 1000))
+
+	policy = &model.RetryPolicy{}
+	require.Equal(t, defaultRetryBaseDelay, retryDelay(policy, 0))
+	require.Positive(t, retryDelay(policy, 1000))
+}
+
+func TestIsRetryable(t *testing.T) {
+	policy := &model.RetryPolicy{}
+	require.True(t, isRetryable(policy, 1))
+	require.False(t, isRetryable(policy, api.ExitCodeTimeout))
+
+	policy = &model.RetryPolicy{ExitCodes: []int{10, 30}, RetryOnTimeout: true}
+	require.True(t, isRetryable(policy, 30))
+	require.False(t, isRetryable(policy, 1))
+	require.True(t, isRetryable(policy, api.ExitCodeTimeout))
+}
@@ -1,9 +1,9 @@
 package server
 
 import (
-	"strings"
-
 	"github.com/go-playground/validator/v10"
+
+	"github.com/ustclug/Yuki/pkg/repoconfig"
 )
 
 type echoValidator func(i any) error
@@ -13,11 +13,5 @@ func (v echoValidator) Validate(i any) error {
 }
 
 func InitValidator() *validator.Validate {
-	validate := validator.New()
-	_ = validate.RegisterValidation("repo-name", func(fl validator.FieldLevel) bool {
-		// Avoid possible issues when using filepath.Join with repo name
-		field := fl.Field().String()
-		return !strings.Contains(field, "/") && field != ".."
-	})
-	return validate
+	return repoconfig.NewValidator()
 }
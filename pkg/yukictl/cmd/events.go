@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type eventsOptions struct {
+	watch  bool
+	output string
+	repos  []string
+}
+
+func (o *eventsOptions) print(w io.Writer, e api.Event) error {
+	if o.output == "json" {
+		return json.NewEncoder(w).Encode(e)
+	}
+	var details []string
+	if e.ExitCode != nil {
+		details = append(details, fmt.Sprintf("exitCode=%d", *e.ExitCode))
+	}
+	if e.NextRun > 0 {
+		details = append(details, "nextRun="+time.Unix(e.NextRun, 0).Format(time.RFC3339))
+	}
+	if len(e.Images) > 0 {
+		details = append(details, "images="+strings.Join(e.Images, ","))
+	}
+	if len(e.Error) > 0 {
+		details = append(details, fmt.Sprintf("error=%q", e.Error))
+	}
+	_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+		time.Unix(e.Time, 0).Format(time.RFC3339),
+		e.Type,
+		e.Repo,
+		strings.Join(details, " "),
+	)
+	return err
+}
+
+func (o *eventsOptions) Run(f factory.Factory) error {
+	req := f.RESTClient().
+		SetTimeout(0).
+		R().
+		SetDoNotParseResponse(true).
+		SetHeader("Accept", "text/event-stream").
+		SetQueryParam("since", "0").
+		SetQueryParamsFromValues(map[string][]string{"repo": o.repos})
+	if !o.watch {
+		req.SetQueryParam("stream", "false")
+	}
+	resp, err := req.Get("api/v1/events")
+	if err != nil {
+		return err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+	if resp.IsError() {
+		data, _ := io.ReadAll(body)
+		return fmt.Errorf("%s: %s", resp.Status(), data)
+	}
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) > 0 {
+			if val, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimSpace(val))
+			}
+			continue
+		}
+		// An empty line marks the end of an event.
+		if data.Len() == 0 {
+			continue
+		}
+		var e api.Event
+		err := json.Unmarshal([]byte(data.String()), &e)
+		data.Reset()
+		if err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		if err := o.print(os.Stdout, e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func NewCmdEvents(f factory.Factory) *cobra.Command {
+	o := eventsOptions{}
+	cmd := &cobra.Command{
+		Use:     "events",
+		Example: "  yukictl events\n  yukictl events --watch --repo REPO",
+		Short:   "Print the recent sync lifecycle events",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(f)
+		},
+	}
+	cmd.Flags().BoolVarP(&o.watch, "watch", "w", false, "Keep printing new events")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "text", "Output format. One of: text, json")
+	cmd.Flags().StringSliceVar(&o.repos, "repo", nil, "Only print the events of the given repos")
+	return cmd
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/tabwriter"
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type historyOptions struct {
+	name     string
+	page     int
+	pageSize int
+}
+
+func (o *historyOptions) Run(f factory.Factory) error {
+	var (
+		errMsg echo.HTTPError
+		result api.ListSyncRecordsResponse
+	)
+	resp, err := f.RESTClient().R().
+		SetError(&errMsg).
+		SetResult(&result).
+		SetPathParam("name", o.name).
+		SetQueryParam("page", strconv.Itoa(o.page)).
+		SetQueryParam("pageSize", strconv.Itoa(o.pageSize)).
+		Get("api/v1/repos/{name}/history")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s", errMsg.Message)
+	}
+
+	tw := tabwriter.New(os.Stdout)
+	tw.SetHeader([]string{"started-at", "duration", "exit-code", "size", "trigger", "upstream", "image-digest"})
+	for _, r := range result.Records {
+		startedAt := ""
+		duration := ""
+		exitCode := ""
+		size := ""
+		if r.StartedAt > 0 {
+			startedAt = time.Unix(r.StartedAt, 0).Format(time.RFC3339)
+		}
+		if r.FinishedAt > 0 {
+			exitCode = strconv.Itoa(r.ExitCode)
+			size = units.BytesSize(float64(r.SizeAfter))
+			if r.TimedOut {
+				exitCode += " (timeout)"
+			} else if r.Cancelled {
+				exitCode += " (cancelled)"
+			}
+			if r.StartedAt > 0 {
+				duration = (time.Duration(r.FinishedAt-r.StartedAt) * time.Second).String()
+			}
+		}
+		tw.Append(
+			startedAt,
+			duration,
+			exitCode,
+			size,
+			r.Trigger,
+			r.Upstream,
+			shortDigest(r.ImageDigest),
+		)
+	}
+	return tw.Render()
+}
+
+// shortDigest turns "ustcmirror/rsync@sha256:<64 hex>" into "sha256:<12 hex>".
+func shortDigest(digest string) string {
+	_, d, ok := strings.Cut(digest, "@")
+	if !ok {
+		d = digest
+	}
+	if len(d) > len("sha256:")+12 {
+		d = d[:len("sha256:")+12]
+	}
+	return d
+}
+
+func NewCmdHistory(f factory.Factory) *cobra.Command {
+	o := historyOptions{}
+	cmd := &cobra.Command{
+		Use:     "history",
+		Args:    cobra.ExactArgs(1),
+		Example: "  yukictl history REPO",
+		Short:   "Show sync history of the repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = stripSuffix(args[0])
+			return o.Run(f)
+		},
+	}
+	cmd.Flags().IntVarP(&o.page, "page", "p", 1, "Page number")
+	cmd.Flags().IntVar(&o.pageSize, "page-size", 20, "Number of records per page")
+	return cmd
+}
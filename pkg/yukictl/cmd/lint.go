@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ustclug/Yuki/pkg/repoconfig"
+)
+
+type lintOptions struct {
+	paths        []string
+	output       string
+	skipDirCheck bool
+}
+
+func (o *lintOptions) Run() error {
+	var (
+		dirs  []string
+		files []string
+	)
+	addDir := func(dir string) {
+		for _, d := range dirs {
+			if d == dir {
+				return
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	for _, p := range o.paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			addDir(p)
+			continue
+		}
+		// Lint the given file with the defaults and templates in its dir.
+		addDir(filepath.Dir(p))
+		files = append(files, filepath.Base(p))
+	}
+
+	issues, err := repoconfig.Lint(dirs, repoconfig.LintOptions{
+		Files:        files,
+		SkipDirCheck: o.skipDirCheck,
+	})
+	if err != nil {
+		return err
+	}
+	if o.output == "json" {
+		if issues == nil {
+			issues = []repoconfig.Issue{}
+		}
+		err = json.NewEncoder(os.Stdout).Encode(issues)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, i := range issues {
+			fmt.Println(i)
+		}
+	}
+	var errCnt int
+	for _, i := range issues {
+		if i.Severity == repoconfig.SeverityError {
+			errCnt++
+		}
+	}
+	if errCnt > 0 {
+		return fmt.Errorf("found %d error(s)", errCnt)
+	}
+	return nil
+}
+
+func NewCmdLint() *cobra.Command {
+	o := lintOptions{}
+	cmd := &cobra.Command{
+		Use:   "lint [paths...]",
+		Short: "Check the repo configs without connecting to yukid",
+		Long: `Check the repo configs without connecting to yukid.
+
+Each path is either a config dir or a config file. Config dirs are merged in the given order,
+like the repo_config_dir of yukid. The current dir is checked if no path is given.`,
+		Example: "  yukictl lint /etc/yuki/repos\n  yukictl lint -o json --skip-dir-check common/ override/",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.paths = args
+			if len(o.paths) == 0 {
+				o.paths = []string{"."}
+			}
+			return o.Run()
+		},
+	}
+	cmd.Flags().StringVarP(&o.output, "output", "o", "text", "Output format. One of: text, json")
+	cmd.Flags().BoolVar(&o.skipDirCheck, "skip-dir-check", false, "Do not check whether storageDir exists")
+	return cmd
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/tabwriter"
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type logsOptions struct {
+	name   string
+	file   string
+	list   bool
+	follow bool
+	tail   int
+}
+
+func (o *logsOptions) listFiles(f factory.Factory) (api.ListLogFilesResponse, error) {
+	var (
+		errMsg echo.HTTPError
+		result api.ListLogFilesResponse
+	)
+	resp, err := f.RESTClient().R().
+		SetError(&errMsg).
+		SetResult(&result).
+		SetPathParam("name", o.name).
+		Get("api/v1/repos/{name}/logs")
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("%s", errMsg.Message)
+	}
+	return result, nil
+}
+
+func (o *logsOptions) Run(f factory.Factory) error {
+	if o.list || len(o.file) == 0 {
+		files, err := o.listFiles(f)
+		if err != nil {
+			return err
+		}
+		if o.list {
+			tw := tabwriter.New(os.Stdout)
+			tw.SetHeader([]string{"name", "size", "mod-time"})
+			for _, file := range files {
+				tw.Append(
+					file.Name,
+					units.BytesSize(float64(file.Size)),
+					time.Unix(file.ModTime, 0).Format(time.RFC3339),
+				)
+			}
+			return tw.Render()
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no log file found for <%s>", o.name)
+		}
+		// The files are sorted by modification time in descending order.
+		o.file = files[0].Name
+	}
+
+	req := f.RESTClient().
+		SetTimeout(0).
+		R().
+		SetDoNotParseResponse(true).
+		SetPathParam("name", o.name).
+		SetPathParam("file", o.file)
+	if o.tail >= 0 {
+		req.SetQueryParam("tail", strconv.Itoa(o.tail))
+	}
+	if o.follow {
+		req.SetQueryParam("follow", "true")
+	}
+	resp, err := req.Get("api/v1/repos/{name}/logs/{file}")
+	if err != nil {
+		return err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+	if resp.IsError() {
+		data, _ := io.ReadAll(body)
+		return fmt.Errorf("%s: %s", resp.Status(), data)
+	}
+	_, err = io.Copy(os.Stdout, body)
+	return err
+}
+
+func NewCmdLogs(f factory.Factory) *cobra.Command {
+	o := logsOptions{}
+	cmd := &cobra.Command{
+		Use:     "logs",
+		Args:    cobra.ExactArgs(1),
+		Example: "  yukictl logs REPO\n  yukictl logs -f --tail 100 REPO\n  yukictl logs --list REPO",
+		Short:   "Print the sync logs of the repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = stripSuffix(args[0])
+			return o.Run(f)
+		},
+	}
+	cmd.Flags().StringVar(&o.file, "file", "", "Name of the log file. Defaults to the latest one")
+	cmd.Flags().BoolVarP(&o.list, "list", "l", false, "List the log files")
+	cmd.Flags().BoolVarP(&o.follow, "follow", "f", false, "Keep printing the log until the sync finishes")
+	cmd.Flags().IntVar(&o.tail, "tail", -1, "Number of lines to show from the end of the log. Defaults to all")
+	return cmd
+}
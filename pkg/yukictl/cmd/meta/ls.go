@@ -15,7 +15,8 @@ import (
 )
 
 type lsOptions struct {
-	name string
+	name  string
+	stale bool
 }
 
 func (o *lsOptions) Run(f factory.Factory) error {
@@ -41,6 +42,9 @@ func (o *lsOptions) Run(f factory.Factory) error {
 	}
 
 	var result api.ListRepoMetasResponse
+	if o.stale {
+		req.SetQueryParam("stale", "true")
+	}
 	resp, err := req.SetResult(&result).Get("api/v1/metas")
 	if err != nil {
 		return err
@@ -49,7 +53,7 @@ func (o *lsOptions) Run(f factory.Factory) error {
 		return fmt.Errorf("%s", errMsg.Message)
 	}
 	tw := tabwriter.New(os.Stdout)
-	tw.SetHeader([]string{"name", "upstream", "syncing", "size", "last-success", "next-run"})
+	tw.SetHeader([]string{"name", "upstream", "syncing", "paused", "size", "last-success", "next-run"})
 	for _, r := range result {
 		lastSuccess := ""
 		nextRun := ""
@@ -63,6 +67,7 @@ func (o *lsOptions) Run(f factory.Factory) error {
 			r.Name,
 			r.Upstream,
 			r.Syncing,
+			r.Paused,
 			units.BytesSize(float64(r.Size)),
 			lastSuccess,
 			nextRun,
@@ -83,5 +88,6 @@ func NewCmdMetaLs(f factory.Factory) *cobra.Command {
 			return o.Run(f)
 		},
 	}
+	cmd.Flags().BoolVar(&o.stale, "stale", false, "Only list the stale repos")
 	return cmd
 }
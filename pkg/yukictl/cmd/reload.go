@@ -1,16 +1,51 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/cobra"
 
+	"github.com/ustclug/Yuki/pkg/api"
 	"github.com/ustclug/Yuki/pkg/yukictl/factory"
 )
 
 type reloadOptions struct {
-	repo string
+	repo   string
+	dryRun bool
+	atomic bool
+}
+
+func printReloadDiff(result api.ReloadResult) {
+	for _, name := range result.Added {
+		fmt.Printf("+ %s\n", name)
+	}
+	for _, name := range result.Removed {
+		fmt.Printf("- %s\n", name)
+	}
+	for _, diff := range result.Changed {
+		fmt.Printf("~ %s\n", diff.Name)
+		for _, change := range diff.Fields {
+			before, _ := json.Marshal(change.Old)
+			after, _ := json.Marshal(change.New)
+			fmt.Printf("    %s: %s -> %s\n", change.Field, before, after)
+		}
+	}
+	for _, e := range result.Errors {
+		fmt.Printf("! %s: %s\n", e.File, e.Error)
+	}
+	printReloadWarnings(result)
+	if len(result.Added)+len(result.Removed)+len(result.Changed)+len(result.Errors) == 0 {
+		fmt.Println("No changes")
+	}
+}
+
+func printReloadWarnings(result api.ReloadResult) {
+	for _, w := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s:%d: %s\n", w.File, w.Line, w.Error)
+	}
 }
 
 func (o *reloadOptions) Run(f factory.Factory) error {
@@ -19,14 +54,28 @@ func (o *reloadOptions) Run(f factory.Factory) error {
 	if len(o.repo) > 0 {
 		path += "/" + o.repo
 	}
-	var errMsg echo.HTTPError
-	resp, err := req.SetError(&errMsg).Post(path)
+	var (
+		errMsg echo.HTTPError
+		result api.ReloadResult
+	)
+	if o.dryRun {
+		req.SetQueryParam("dryRun", "true")
+	}
+	if o.atomic {
+		req.SetQueryParam("atomic", "true")
+	}
+	resp, err := req.SetError(&errMsg).SetResult(&result).Post(path)
 	if err != nil {
 		return err
 	}
 	if resp.IsError() {
 		return fmt.Errorf("%s", errMsg.Message)
 	}
+	if o.dryRun {
+		printReloadDiff(result)
+		return nil
+	}
+	printReloadWarnings(result)
 	if len(o.repo) > 0 {
 		fmt.Printf("Successfully reloaded: <%s>\n", o.repo)
 	} else {
@@ -43,9 +92,14 @@ func NewCmdReload(f factory.Factory) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 0 {
 				o.repo = stripSuffix(args[0])
+				if o.dryRun || o.atomic {
+					return fmt.Errorf("--dry-run and --atomic can only be used when reloading all repos")
+				}
 			}
 			return o.Run(f)
 		},
 	}
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Print the changes without applying them")
+	cmd.Flags().BoolVar(&o.atomic, "atomic", false, "Apply all the changes or nothing")
 	return cmd
 }
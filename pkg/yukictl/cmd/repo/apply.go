@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ustclug/Yuki/pkg/model"
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type applyOptions struct {
+	file    string
+	persist bool
+}
+
+func (o *applyOptions) Run(f factory.Factory) error {
+	var (
+		data []byte
+		err  error
+	)
+	if o.file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(o.file)
+	}
+	if err != nil {
+		return err
+	}
+	var repo model.Repo
+	err = yaml.Unmarshal(data, &repo)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", o.file, err)
+	}
+	if len(repo.Name) == 0 {
+		return fmt.Errorf("name is required in %s", o.file)
+	}
+
+	var errMsg echo.HTTPError
+	req := f.RESTClient().R().
+		SetError(&errMsg).
+		SetHeader("Content-Type", "application/yaml").
+		SetBody(data).
+		SetPathParam("name", repo.Name)
+	if o.persist {
+		req.SetQueryParam("persist", "true")
+	}
+	resp, err := req.Put("api/v1/repos/{name}")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s", errMsg.Message)
+	}
+	if resp.StatusCode() == http.StatusCreated {
+		fmt.Printf("Created: <%s>\n", repo.Name)
+	} else {
+		fmt.Printf("Updated: <%s>\n", repo.Name)
+	}
+	return nil
+}
+
+func NewCmdRepoApply(f factory.Factory) *cobra.Command {
+	o := applyOptions{}
+	cmd := &cobra.Command{
+		Use:     "apply",
+		Short:   "Create or update repository from a YAML file",
+		Example: "  yukictl repo apply -f repo.yaml\n  yukictl repo apply --persist -f repo.yaml",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.Run(f)
+		},
+	}
+	cmd.Flags().StringVarP(&o.file, "file", "f", "", "Path of the YAML file. Use - to read from stdin")
+	cmd.Flags().BoolVar(&o.persist, "persist", false, "Also save the config into the repo_config_dir of yukid")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type getOptions struct {
+	name     string
+	rendered bool
+}
+
+func (o *getOptions) Run(f factory.Factory) error {
+	var (
+		errMsg echo.HTTPError
+		result map[string]any
+	)
+	req := f.RESTClient().R().
+		SetError(&errMsg).
+		SetResult(&result).
+		SetPathParam("name", o.name)
+	if o.rendered {
+		req.SetQueryParam("rendered", "true")
+	}
+	resp, err := req.Get("api/v1/repos/{name}")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s", errMsg.Message)
+	}
+	data, err := yaml.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func NewCmdRepoGet(f factory.Factory) *cobra.Command {
+	o := getOptions{}
+	cmd := &cobra.Command{
+		Use:     "get",
+		Short:   "Print the config of the repository in YAML",
+		Example: "  yukictl repo get REPO\n  yukictl repo get --rendered REPO",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = args[0]
+			return o.Run(f)
+		},
+	}
+	cmd.Flags().BoolVar(&o.rendered, "rendered", false, "Render the config file with defaults and templates instead of printing the loaded config")
+	return cmd
+}
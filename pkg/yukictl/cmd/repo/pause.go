@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+
+	"github.com/ustclug/Yuki/pkg/api"
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type pauseOptions struct {
+	name     string
+	reason   string
+	duration time.Duration
+}
+
+func (o *pauseOptions) Run(f factory.Factory) error {
+	var errMsg echo.HTTPError
+	body := api.PauseRepoRequest{
+		Reason: o.reason,
+	}
+	if o.duration > 0 {
+		body.Until = time.Now().Add(o.duration).Unix()
+	}
+	resp, err := f.RESTClient().R().
+		SetError(&errMsg).
+		SetBody(body).
+		SetPathParam("name", o.name).
+		Post("api/v1/repos/{name}/pause")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s", errMsg.Message)
+	}
+	if body.Until > 0 {
+		fmt.Printf("Paused <%s> until %s\n", o.name, time.Unix(body.Until, 0).Format(time.RFC3339))
+	} else {
+		fmt.Printf("Paused <%s>\n", o.name)
+	}
+	return nil
+}
+
+func NewCmdRepoPause(f factory.Factory) *cobra.Command {
+	o := pauseOptions{}
+	cmd := &cobra.Command{
+		Use:     "pause",
+		Short:   "Stop scheduling the repository",
+		Example: "  yukictl repo pause REPO\n  yukictl repo pause --reason 'upstream outage' --for 24h REPO",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = args[0]
+			return o.Run(f)
+		},
+	}
+	cmd.Flags().StringVar(&o.reason, "reason", "", "Why the repository is paused")
+	cmd.Flags().DurationVar(&o.duration, "for", 0, "Resume the repository automatically after the given duration")
+	return cmd
+}
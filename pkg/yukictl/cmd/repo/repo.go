@@ -13,7 +13,11 @@ func NewCmdRepo(f factory.Factory) *cobra.Command {
 	}
 	cmd.AddCommand(
 		NewCmdRepoLs(f),
+		NewCmdRepoGet(f),
 		NewCmdRepoRm(f),
+		NewCmdRepoApply(f),
+		NewCmdRepoPause(f),
+		NewCmdRepoResume(f),
 	)
 	return cmd
 }
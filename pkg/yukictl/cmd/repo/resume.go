@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+
+	"github.com/ustclug/Yuki/pkg/yukictl/factory"
+)
+
+type resumeOptions struct {
+	name string
+}
+
+func (o *resumeOptions) Run(f factory.Factory) error {
+	var errMsg echo.HTTPError
+	resp, err := f.RESTClient().R().
+		SetError(&errMsg).
+		SetPathParam("name", o.name).
+		Post("api/v1/repos/{name}/resume")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s", errMsg.Message)
+	}
+	fmt.Printf("Resumed <%s>\n", o.name)
+	return nil
+}
+
+func NewCmdRepoResume(f factory.Factory) *cobra.Command {
+	o := resumeOptions{}
+	return &cobra.Command{
+		Use:     "resume",
+		Short:   "Resume scheduling the repository",
+		Example: "  yukictl repo resume REPO",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = args[0]
+			return o.Run(f)
+		},
+	}
+}
@@ -10,13 +10,28 @@ import (
 )
 
 type syncOptions struct {
-	debug bool
-	name  string
+	debug  bool
+	cancel bool
+	name   string
 }
 
 func (o *syncOptions) Run(f factory.Factory) error {
 	req := f.RESTClient().R()
 	var errMsg echo.HTTPError
+	if o.cancel {
+		resp, err := req.
+			SetError(&errMsg).
+			SetPathParam("name", o.name).
+			Delete("api/v1/repos/{name}/sync")
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return fmt.Errorf("%s", errMsg.Message)
+		}
+		fmt.Printf("Cancelled syncing <%s>\n", o.name)
+		return nil
+	}
 	if o.debug {
 		req.SetQueryParam("debug", "true")
 	}
@@ -40,7 +55,7 @@ func NewCmdSync(f factory.Factory) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "sync",
 		Args:    cobra.ExactArgs(1),
-		Example: "  yukictl sync REPO",
+		Example: "  yukictl sync REPO\n  yukictl sync --cancel REPO",
 		Short:   "Sync local repository with remote",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			o.name = stripSuffix(args[0])
@@ -48,5 +63,7 @@ func NewCmdSync(f factory.Factory) *cobra.Command {
 		},
 	}
 	cmd.Flags().BoolVarP(&o.debug, "debug", "v", false, "Debug mode")
+	cmd.Flags().BoolVar(&o.cancel, "cancel", false, "Cancel the running or queued sync")
+	cmd.MarkFlagsMutuallyExclusive("debug", "cancel")
 	return cmd
 }
@@ -3,17 +3,25 @@ package factory
 import (
 	"encoding/json"
 	"io"
+	"os"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/spf13/pflag"
 )
 
+const envToken = "YUKI_TOKEN"
+
 type factoryImpl struct {
 	remote string
+	token  string
 }
 
 func (f *factoryImpl) RESTClient() *resty.Client {
-	return resty.New().SetBaseURL(f.remote)
+	cli := resty.New().SetBaseURL(f.remote)
+	if len(f.token) > 0 {
+		cli.SetAuthToken(f.token)
+	}
+	return cli
 }
 
 func (f *factoryImpl) JSONEncoder(w io.Writer) *json.Encoder {
@@ -25,5 +33,6 @@ func (f *factoryImpl) JSONEncoder(w io.Writer) *json.Encoder {
 func New(flags *pflag.FlagSet) Factory {
 	s := factoryImpl{}
 	flags.StringVarP(&s.remote, "remote", "r", "http://127.0.0.1:9999/", "Remote address")
+	flags.StringVar(&s.token, "token", os.Getenv(envToken), "Bearer token for the private APIs. Defaults to $"+envToken)
 	return &s
 }
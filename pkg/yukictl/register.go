@@ -12,6 +12,10 @@ import (
 func Register(root *cobra.Command, f factory.Factory) {
 	root.AddCommand(
 		cmd.NewCmdCompletion(),
+		cmd.NewCmdEvents(f),
+		cmd.NewCmdHistory(f),
+		cmd.NewCmdLint(),
+		cmd.NewCmdLogs(f),
 		cmd.NewCmdReload(f),
 		cmd.NewCmdSync(f),
 		meta.NewCmdMeta(f),